@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"server/common/listener"
+	"server/common/routing"
+	"server/common/store"
+	"server/common/transport"
+	"server/config"
+	"server/handler/events"
+	"server/handler/sync"
+)
+
+// commandDeps are the dependencies of CLI subcommands
+type commandDeps struct {
+	db            store.Store
+	subscriber    transport.Subscriber
+	syncHandler   *sync.Handler
+	eventsHandler *events.Handler
+	routes        *routing.Table
+	// processors maps PubSub push endpoint path to its message processor
+	processors map[string]transport.Handler
+}
+
+// runCommand runs CLI subcommand
+func runCommand(ctx context.Context, name string, args []string, deps *commandDeps) {
+	switch name {
+	case "reconcile":
+		reconcileCommand(ctx, args, deps.syncHandler)
+	case "worker":
+		workerCommand(ctx, args, deps)
+	case "listen":
+		listenCommand(ctx, args, deps)
+	case "sync-topics":
+		syncTopicsCommand(ctx, deps.eventsHandler)
+	case "backfill-responses":
+		backfillResponsesCommand(ctx, deps.db)
+	default:
+		log.Fatalln("Unknown command:", name)
+	}
+}
+
+// reconcileCommand runs full reconciliation between Miniflux and the store and prints the report
+func reconcileCommand(ctx context.Context, args []string, syncHandler *sync.Handler) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	since := fs.Duration("since", config.ReconcileWindow, "reconcile entries published within this duration, eg. 72h")
+	dryRun := fs.Bool("dry-run", false, "only report the differences")
+	rate := fs.Int("rate", config.ReconcileRate, "max Miniflux calls and store writes per second, 0 means unlimited")
+	fs.Parse(args)
+
+	report, err := syncHandler.Reconcile(ctx, sync.ReconcileOptions{
+		Since:  time.Now().Add(-*since),
+		DryRun: *dryRun,
+		Rate:   *rate,
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+
+	if err != nil {
+		log.Fatalln("Reconcile failed:", err)
+	}
+}
+
+// listenCommand relays Firestore changes of entries and entry_responses to events handler
+// until SIGINT or SIGTERM, replacing the Firesub Cloud Functions.
+func listenCommand(ctx context.Context, args []string, deps *commandDeps) {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	window := fs.Duration("window", config.ListenerWindow, "watch entries published within this duration, eg. 24h")
+	fs.Parse(args)
+
+	if config.Store != "firestore" {
+		log.Fatalln("Listener requires firestore STORE, got:", config.Store)
+	}
+	if err := gcp.InitFirestore(ctx); err != nil {
+		log.Fatalln("Unable to initialize Firestore:", err)
+	}
+
+	path := "/pubsub/firestore-events" // failed events are replayable as if received from Firesub
+	l := listener.New(gcp.Firestore, workerHandler(deps.db, path, "firestore-listener", deps.processors[path]))
+	l.Window = *window
+
+	ctx, cancel := withSignals(ctx)
+	defer cancel()
+	l.Listen(ctx, deps.routes.Collections(), config.TopicCategories)
+}
+
+// syncTopicsCommand subscribes devices of topic mode categories subscribers to the category FCM topic and prints the report
+func syncTopicsCommand(ctx context.Context, eventsHandler *events.Handler) {
+	reports, err := eventsHandler.SyncTopics(ctx)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(reports)
+
+	if err != nil {
+		log.Fatalln("Sync topics failed:", err)
+	}
+}
+
+// backfillResponsesCommand sets created_at of Firestore entry responses written before it was added,
+// other stores order them by ID instead.
+func backfillResponsesCommand(ctx context.Context, db store.Store) {
+	fsdb, ok := db.(*store.Firestore)
+	if !ok {
+		log.Fatalln("Backfill requires firestore STORE, got:", config.Store)
+	}
+	n, err := fsdb.BackfillResponses(ctx)
+	if err != nil {
+		log.Fatalln("Backfill failed:", err)
+	}
+	log.Printf("Backfilled created_at of %d responses\n", n)
+}
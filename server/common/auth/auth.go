@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gofiber/fiber"
+
+	"server/common/service"
+)
+
+const (
+	// LocalsUID is the c.Locals key of authenticated user ID
+	LocalsUID = "auth_uid"
+	// LocalsClaims is the c.Locals key of authenticated user ID token claims
+	LocalsClaims = "auth_claims"
+)
+
+// Optional returns middleware that authenticates the request when it has Firebase ID token
+// in "Authorization: Bearer <token>" header, anonymous request is passed through.
+// Request with invalid token is rejected.
+func Optional(v service.Verifier) func(*fiber.Ctx) {
+	return middleware(v, false)
+}
+
+// Required returns middleware that rejects request without valid Firebase ID token
+func Required(v service.Verifier) func(*fiber.Ctx) {
+	return middleware(v, true)
+}
+
+func middleware(v service.Verifier, required bool) func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		// already authenticated by previous middleware
+		if UID(c) != "" {
+			c.Next()
+			return
+		}
+
+		header := c.Get("Authorization")
+		if header == "" {
+			if required {
+				unauthorized(c, "missing bearer token")
+				return
+			}
+			c.Next()
+			return
+		}
+		if !strings.HasPrefix(header, "Bearer ") {
+			unauthorized(c, "authorization must be bearer token")
+			return
+		}
+
+		token, err := v.VerifyIDToken(context.Background(), strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if err != nil {
+			log.Println("[AUTH] invalid ID token:", err)
+			unauthorized(c, "invalid token")
+			return
+		}
+		c.Locals(LocalsUID, token.UID)
+		c.Locals(LocalsClaims, claims(token))
+		c.Next()
+	}
+}
+
+// claims returns custom and Firebase claims of the token, never nil
+func claims(token *fbauth.Token) map[string]interface{} {
+	if token.Claims == nil {
+		return map[string]interface{}{}
+	}
+	return token.Claims
+}
+
+func unauthorized(c *fiber.Ctx, reason string) {
+	c.Set("WWW-Authenticate", "Bearer")
+	c.Status(http.StatusUnauthorized).JSON(map[string]string{"error": reason})
+}
+
+// UID returns authenticated user ID, empty for anonymous request
+func UID(c *fiber.Ctx) string {
+	uid, _ := c.Locals(LocalsUID).(string)
+	return uid
+}
+
+// Claims returns ID token claims of authenticated user, nil for anonymous request
+func Claims(c *fiber.Ctx) map[string]interface{} {
+	v, _ := c.Locals(LocalsClaims).(map[string]interface{})
+	return v
+}
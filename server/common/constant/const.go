@@ -27,4 +27,30 @@ const (
 	BaliUnited = "baliunited"
 	// EntryResponses collection for responses/comments entries
 	EntryResponses = "entry_responses"
+	// EntryIndex is collection of entry locations keyed by Miniflux entry ID
+	EntryIndex = "entry_index"
+	// ProcessedMessages is the ledger of processed Pub/Sub messages
+	ProcessedMessages = "processed_messages"
+	// MessageAttempts is the delivery attempts counter of failed Pub/Sub messages
+	MessageAttempts = "message_attempts"
+	// Counters is collection of fixed window counters, eg. hourly push notifications of users
+	Counters = "counters"
+	// DeadLetters is collection of Pub/Sub messages that failed too many times
+	DeadLetters = "dead_letters"
+	// PendingNotifications is collection of push notifications held for later delivery
+	PendingNotifications = "pending_notifications"
+	// Deliveries is collection of push notification delivery records
+	Deliveries = "deliveries"
+	// DeliveryStats is collection of daily push notification delivery counters
+	DeliveryStats = "delivery_stats"
+	// CollectionStats is collection of entry counts, keyed by entries collection
+	CollectionStats = "collection_stats"
+	// ListenerCheckpoints is collection of Firestore listener checkpoints, keyed by listened collection
+	ListenerCheckpoints = "listener_checkpoints"
+	// TopicUsers is collection of users subscribed to FCM topics, keyed by {topic}_{user id}
+	TopicUsers = "topic_users"
+	// Users is collection for app users
+	Users = "users"
+	// Notifications is the inbox subcollection of user, users/{id}/notifications
+	Notifications = "notifications"
 )
@@ -0,0 +1,72 @@
+package fault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"server/common/miniflux"
+	"server/common/store"
+)
+
+// Error is an error explicitly classified as retryable or permanent
+type Error struct {
+	Err       error
+	Retryable bool
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as permanent, retrying the message won't help (eg. bad payload)
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err}
+}
+
+// Permanentf is Permanent(fmt.Errorf(format, args...))
+func Permanentf(format string, args ...interface{}) error {
+	return Permanent(fmt.Errorf(format, args...))
+}
+
+// Retryable marks err as transient, the message should be redelivered later
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Retryable: true}
+}
+
+// IsRetryable reports whether the message that failed with err should be redelivered.
+// Explicitly classified errors are respected, Miniflux errors are classified by miniflux.IsRetryable,
+// missing documents and malformed JSON are permanent, anything else is assumed transient (eg. Firestore outage).
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+	var mfErr *miniflux.Error
+	if errors.As(err, &mfErr) {
+		return miniflux.IsRetryable(err)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package fault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"server/common/miniflux"
+	"server/common/store"
+)
+
+func TestIsRetryable(t *testing.T) {
+	var v map[string]interface{}
+	jsonErr := json.Unmarshal([]byte("{"), &v)
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("firestore unavailable"), true},
+		{Permanent(errors.New("bad payload")), false},
+		{fmt.Errorf("wrapped: %w", Permanentf("no tokens")), false},
+		{Retryable(store.ErrNotFound), true},
+		{store.ErrNotFound, false},
+		{fmt.Errorf("get user: %w", store.ErrNotFound), false},
+		{jsonErr, false},
+		{&miniflux.Error{StatusCode: http.StatusNotFound}, false},
+		{fmt.Errorf("storeEntry failed: %w", &miniflux.Error{StatusCode: http.StatusBadGateway}), true},
+		{miniflux.ErrCircuitOpen, true},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"server/common/constant"
+	"server/common/fault"
+	"server/common/transport"
+)
+
+const (
+	typeEntries     = "entries"
+	typeResponses   = "responses"
+	typeSubscribers = "subscribers"
+)
+
+// Listener watches Firestore entries, entry_responses and categories subscribers collections and feeds the changes
+// to events handler in Firesub message format, replacing the Firesub Cloud Functions relay.
+//
+// The last processed snapshot time is checkpointed per collection, after restart
+// documents created or updated since the checkpoint are replayed.
+// Changes whose previous state is unknown (updated or deleted while not listening) can't be replayed.
+type Listener struct {
+	// Window limits watched entries to those published within this duration
+	Window time.Duration
+	// RetryDelay is the delay before retrying failed message or broken listener, multiplied by the attempt
+	RetryDelay time.Duration
+
+	client  *firestore.Client
+	handler transport.Handler
+}
+
+// New returns Listener instance, h receives the same messages as /pubsub/firestore-events
+func New(client *firestore.Client, h transport.Handler) *Listener {
+	return &Listener{Window: 24 * time.Hour, RetryDelay: time.Second, client: client, handler: h}
+}
+
+// Listen watches entry collections, entry_responses and subscribers of categories, blocks until ctx is done
+func (l *Listener) Listen(ctx context.Context, entryCollections, subscriberCategories []string) {
+	var wg sync.WaitGroup
+	watch := func(collection, datatype string) {
+		defer wg.Done()
+		for attempt := 1; ; attempt++ {
+			err := l.listen(ctx, collection, datatype)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[ERROR] listener of %s stopped, restarting: %s\n", collection, err)
+			if !l.sleep(ctx, attempt) {
+				return
+			}
+		}
+	}
+
+	for _, c := range entryCollections {
+		wg.Add(1)
+		go watch(c, typeEntries)
+	}
+	wg.Add(1)
+	go watch(constant.EntryResponses, typeResponses)
+	for _, id := range subscriberCategories {
+		wg.Add(1)
+		go watch(constant.Categories+"/"+id+"/subscribers", typeSubscribers)
+	}
+	wg.Wait()
+}
+
+// listen processes collection snapshots until ctx is done or the listener fails
+func (l *Listener) listen(ctx context.Context, collection, datatype string) error {
+	checkpoint, err := l.checkpoint(ctx, collection)
+	if err != nil {
+		return err
+	}
+
+	q := l.client.Collection(collection).Query
+	if datatype == typeEntries {
+		since := time.Now().Add(-l.Window).Unix() * 1000 // published_at is in millisecs
+		q = q.Where("published_at", ">", since)
+	}
+	it := q.Snapshots(ctx)
+	defer it.Stop()
+
+	// last known data of documents, the "before" of modified documents
+	docs := make(map[string]map[string]interface{})
+	initial := true
+
+	for {
+		snap, err := it.Next()
+		if err != nil {
+			return err
+		}
+
+		for _, change := range snap.Changes {
+			var msg *transport.Message
+			if initial {
+				msg = replay(collection, datatype, change.Doc, checkpoint)
+			} else {
+				msg = newMessage(collection, datatype, change, docs[change.Doc.Ref.ID], snap.ReadTime)
+			}
+			if change.Kind == firestore.DocumentRemoved {
+				delete(docs, change.Doc.Ref.ID)
+			} else if datatype != typeEntries {
+				docs[change.Doc.Ref.ID] = change.Doc.Data()
+			}
+
+			if msg == nil {
+				continue
+			}
+			if err := l.deliver(ctx, msg); err != nil {
+				return err
+			}
+		}
+		initial = false
+
+		if err := l.saveCheckpoint(ctx, collection, snap.ReadTime); err != nil {
+			return err
+		}
+	}
+}
+
+// replay returns message of document changed since checkpoint, found in the initial snapshot.
+// Nothing is replayed on the very first run (zero checkpoint).
+func replay(collection, datatype string, doc *firestore.DocumentSnapshot, checkpoint time.Time) *transport.Message {
+	if checkpoint.IsZero() {
+		return nil
+	}
+	if doc.CreateTime.After(checkpoint) {
+		return newMessage(collection, datatype, firestore.DocumentChange{Kind: firestore.DocumentAdded, Doc: doc}, nil, doc.CreateTime)
+	}
+	if datatype != typeEntries && doc.UpdateTime.After(checkpoint) {
+		log.Printf("[SKIP] %s/%s updated while not listening, previous state unknown\n", collection, doc.Ref.ID)
+	}
+	return nil
+}
+
+// newMessage converts document change into Firesub message, returns nil when the change is not relayed.
+// Entries only relay created documents, like Firesub FirestoreOnCreate.
+func newMessage(collection, datatype string, change firestore.DocumentChange, before map[string]interface{}, readTime time.Time) *transport.Message {
+	doc := change.Doc
+	timestamp := doc.UpdateTime
+	if change.Kind == firestore.DocumentRemoved {
+		timestamp = readTime
+	}
+
+	var payload interface{}
+	switch {
+	case datatype == typeEntries && change.Kind == firestore.DocumentAdded:
+		payload = map[string]interface{}{
+			"id":        doc.Ref.ID,
+			"timestamp": timestamp.Format(time.RFC3339Nano),
+			"data":      doc.Data(),
+		}
+	case datatype == typeResponses || datatype == typeSubscribers:
+		data := map[string]interface{}{
+			"id":        doc.Ref.ID,
+			"timestamp": timestamp.Format(time.RFC3339Nano),
+			"before":    nil,
+			"after":     nil,
+		}
+		if datatype == typeSubscribers {
+			data["category_id"] = strings.Split(collection, "/")[1] // categories/{id}/subscribers
+		}
+		switch change.Kind {
+		case firestore.DocumentAdded:
+			data["after"] = doc.Data()
+		case firestore.DocumentModified:
+			if before == nil {
+				log.Printf("[SKIP] %s/%s modified, previous state unknown\n", collection, doc.Ref.ID)
+				return nil
+			}
+			data["before"], data["after"] = before, doc.Data()
+		case firestore.DocumentRemoved:
+			data["before"] = doc.Data()
+		}
+		payload = data
+	default:
+		return nil
+	}
+
+	j, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[ERROR] unable to encode %s/%s: %s\n", collection, doc.Ref.ID, err)
+		return nil
+	}
+	return &transport.Message{
+		ID:         messageID(collection, doc.Ref.ID, timestamp),
+		Data:       j,
+		Attributes: map[string]string{"type": datatype},
+	}
+}
+
+// deliver calls the handler until msg is handled or failed permanently,
+// returns error only when ctx is done so the checkpoint is not advanced.
+func (l *Listener) deliver(ctx context.Context, msg *transport.Message) error {
+	for attempt := 1; ; attempt++ {
+		msg.DeliveryAttempt = attempt
+		err := l.handler(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		if !fault.IsRetryable(err) {
+			log.Printf("[ERROR] message %s: %s\n", msg.ID, err)
+			return nil
+		}
+		log.Printf("[RETRY] attempt %d of message %s: %s\n", attempt, msg.ID, err)
+		if !l.sleep(ctx, attempt) {
+			return ctx.Err()
+		}
+	}
+}
+
+// sleep waits RetryDelay*attempt, returns false when ctx is done
+func (l *Listener) sleep(ctx context.Context, attempt int) bool {
+	select {
+	case <-time.After(l.RetryDelay * time.Duration(attempt)):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type checkpointRecord struct {
+	ReadTime time.Time `firestore:"read_time"`
+}
+
+// checkpoint returns the last processed snapshot time of collection, zero when never listened
+func (l *Listener) checkpoint(ctx context.Context, collection string) (time.Time, error) {
+	doc, err := l.client.Collection(constant.ListenerCheckpoints).Doc(checkpointID(collection)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	var rec checkpointRecord
+	if err := doc.DataTo(&rec); err != nil {
+		return time.Time{}, err
+	}
+	return rec.ReadTime, nil
+}
+
+func (l *Listener) saveCheckpoint(ctx context.Context, collection string, readTime time.Time) error {
+	_, err := l.client.Collection(constant.ListenerCheckpoints).Doc(checkpointID(collection)).Set(ctx, &checkpointRecord{ReadTime: readTime})
+	return err
+}
+
+// messageID returns message ID of document change, without "/" as it's used as dead letter document ID,
+// eg. "listener:categories_13_subscribers_u1@2020-01-10T08:00:00Z"
+func messageID(collection, docID string, timestamp time.Time) string {
+	return "listener:" + checkpointID(collection) + "_" + docID + "@" + timestamp.Format(time.RFC3339Nano)
+}
+
+// checkpointID returns checkpoint document ID of collection path, eg. "categories_13_subscribers"
+func checkpointID(collection string) string {
+	return strings.Replace(collection, "/", "_", -1)
+}
@@ -0,0 +1,40 @@
+package listener
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"server/common/store"
+	"server/common/types"
+)
+
+func TestMessageDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+
+	doc := &firestore.DocumentSnapshot{
+		Ref:        &firestore.DocumentRef{ID: "u1"},
+		UpdateTime: time.Date(2020, 1, 10, 8, 0, 0, 0, time.UTC),
+	}
+	change := firestore.DocumentChange{Kind: firestore.DocumentAdded, Doc: doc}
+	msg := newMessage("categories/13/subscribers", typeSubscribers, change, nil, doc.UpdateTime)
+	if msg.ID != "listener:categories_13_subscribers_u1@2020-01-10T08:00:00Z" {
+		t.Errorf("Unexpected message ID %q", msg.ID)
+	}
+
+	// Firestore rejects document IDs with "/"
+	if strings.Contains(msg.ID, "/") {
+		t.Fatalf("Message ID %q can't be dead letter document ID", msg.ID)
+	}
+	if err := db.SaveDeadLetter(ctx, &types.DeadLetter{ID: msg.ID, Path: "/pubsub/firestore-events", Data: msg.Data}); err != nil {
+		t.Fatal(err)
+	}
+	dl, err := db.GetDeadLetter(ctx, msg.ID)
+	if err != nil || string(dl.Data) != string(msg.Data) {
+		t.Errorf("GetDeadLetter() = %+v, %v", dl, err)
+	}
+}
@@ -0,0 +1,239 @@
+package miniflux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/common/types"
+)
+
+// Config is the Client configuration
+type Config struct {
+	// BaseURL is Miniflux host, eg. https://miniflux.example.com
+	BaseURL string
+	// Username and Password for Basic authentication
+	Username string
+	Password string
+	// Token is API key, sent as X-Auth-Token header. Takes precedence over Basic authentication.
+	Token string
+	// HTTPClient is optional, default to client with 5secs timeout
+	HTTPClient *http.Client
+
+	// MaxRetries is number of retries for retryable errors, 0 means no retry
+	MaxRetries int
+	// RetryDelay is the initial backoff delay, doubled on each retry. Default to 500ms.
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the backoff delay. Default to 10secs.
+	MaxRetryDelay time.Duration
+	// BreakerThreshold is number of consecutive failures that opens the circuit breaker, 0 disables it
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit breaker stays open. Default to 30secs.
+	BreakerCooldown time.Duration
+}
+
+// Client is Miniflux API client
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	token      string
+	httpClient *http.Client
+
+	maxRetries    int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	breaker       *breaker
+}
+
+// New returns Client instance
+func New(config Config) *Client {
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: time.Duration(5) * time.Second}
+	}
+	c := &Client{
+		baseURL:       strings.TrimRight(config.BaseURL, "/"),
+		username:      config.Username,
+		password:      config.Password,
+		token:         config.Token,
+		httpClient:    httpClient,
+		maxRetries:    config.MaxRetries,
+		retryDelay:    config.RetryDelay,
+		maxRetryDelay: config.MaxRetryDelay,
+	}
+	if c.retryDelay <= 0 {
+		c.retryDelay = 500 * time.Millisecond
+	}
+	if c.maxRetryDelay <= 0 {
+		c.maxRetryDelay = 10 * time.Second
+	}
+	if config.BreakerThreshold > 0 {
+		cooldown := config.BreakerCooldown
+		if cooldown <= 0 {
+			cooldown = 30 * time.Second
+		}
+		c.breaker = newBreaker(config.BreakerThreshold, cooldown)
+	}
+	return c
+}
+
+// EntryFilter is the filter for listing entries, zero value fields are ignored.
+type EntryFilter struct {
+	Status     string // unread, read or removed
+	After      int64  // unix timestamp, entries published after this time
+	CategoryID int64
+	Order      string // id, status, published_at, category_title or category_id
+	Direction  string // asc or desc
+	Limit      int
+	Offset     int
+}
+
+func (f *EntryFilter) values() url.Values {
+	v := url.Values{}
+	if f == nil {
+		return v
+	}
+	if f.Status != "" {
+		v.Set("status", f.Status)
+	}
+	if f.After > 0 {
+		v.Set("after", strconv.FormatInt(f.After, 10))
+	}
+	if f.CategoryID > 0 {
+		v.Set("category_id", strconv.FormatInt(f.CategoryID, 10))
+	}
+	if f.Order != "" {
+		v.Set("order", f.Order)
+	}
+	if f.Direction != "" {
+		v.Set("direction", f.Direction)
+	}
+	if f.Limit > 0 {
+		v.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Offset > 0 {
+		v.Set("offset", strconv.Itoa(f.Offset))
+	}
+	return v
+}
+
+// EntryResultSet is the response of entries listing
+type EntryResultSet struct {
+	Total   int             `json:"total"`
+	Entries []*types.MEntry `json:"entries"`
+}
+
+// get calls GET path and decodes JSON response into out,
+// retryable errors are retried and counted by the circuit breaker.
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.getWithRetry(ctx, func(ctx context.Context) error {
+		return c.do(ctx, path, query, out)
+	})
+}
+
+// do makes single GET request
+func (c *Client) do(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("X-Auth-Token", c.token)
+	} else {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return newError(path, res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("miniflux: unable to decode %s response: %v", path, err)
+	}
+	return nil
+}
+
+// newError creates Error from non 200 response
+func newError(path string, res *http.Response) *Error {
+	e := &Error{StatusCode: res.StatusCode, Path: path}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	var msg struct {
+		ErrorMessage string `json:"error_message"`
+	}
+	if json.Unmarshal(body, &msg) == nil {
+		e.Message = msg.ErrorMessage
+	}
+	return e
+}
+
+// Categories calls /v1/categories
+func (c *Client) Categories(ctx context.Context) (types.CategoryList, error) {
+	var categories types.CategoryList
+	if err := c.get(ctx, "/v1/categories", nil, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// Feed calls /v1/feeds/:ID
+func (c *Client) Feed(ctx context.Context, id int64) (*types.MFeed, error) {
+	var feed types.MFeed
+	if err := c.get(ctx, fmt.Sprintf("/v1/feeds/%v", id), nil, &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// Feeds calls /v1/feeds
+func (c *Client) Feeds(ctx context.Context) ([]*types.MFeed, error) {
+	var feeds []*types.MFeed
+	if err := c.get(ctx, "/v1/feeds", nil, &feeds); err != nil {
+		return nil, err
+	}
+	return feeds, nil
+}
+
+// FeedIcon calls /v1/feeds/:FeedID/icon
+func (c *Client) FeedIcon(ctx context.Context, feedID int64) (*types.FeedIcon, error) {
+	var icon types.FeedIcon
+	if err := c.get(ctx, fmt.Sprintf("/v1/feeds/%v/icon", feedID), nil, &icon); err != nil {
+		return nil, err
+	}
+	return &icon, nil
+}
+
+// Entry calls /v1/entries/:ID
+func (c *Client) Entry(ctx context.Context, id int64) (*types.MEntry, error) {
+	var entry types.MEntry
+	if err := c.get(ctx, fmt.Sprintf("/v1/entries/%v", id), nil, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Entries calls /v1/entries, filter is optional
+func (c *Client) Entries(ctx context.Context, filter *EntryFilter) (*EntryResultSet, error) {
+	var result EntryResultSet
+	if err := c.get(ctx, "/v1/entries", filter.values(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,131 @@
+package miniflux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newMinifluxServer returns a stand-in of Miniflux API
+func newMinifluxServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/categories", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 1, "title": "Badung", "user_id": 1}, {"id": 2, "title": "Bangli", "user_id": 1}]`))
+	})
+	mux.HandleFunc("/v1/feeds/2", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 2, "user_id": 1, "title": "example.com - Badung", "category": {"id": 1, "title": "Badung"}}`))
+	})
+	mux.HandleFunc("/v1/feeds/2/icon", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 5, "mime_type": "image/png", "data": "image/png;base64,xxx"}`))
+	})
+	mux.HandleFunc("/v1/entries/1000", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1000, "feed_id": 2, "title": "Test Title", "published_at": "2019-05-04T09:59:25Z"}`))
+	})
+	mux.HandleFunc("/v1/entries/1001", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1001, "title": `)) // broken JSON
+	})
+	mux.HandleFunc("/v1/entries", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("status") != "unread" || q.Get("after") != "1556963965" || q.Get("category_id") != "3" || q.Get("limit") != "50" {
+			t.Errorf("Wrong entries query: %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"total": 2, "entries": [{"id": 1}, {"id": 2}]}`))
+	})
+	mux.HandleFunc("/v1/entries/500", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error_message": "database error"}`, http.StatusInternalServerError)
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("X-Auth-Token"); token != "" {
+			if token != "secret-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		} else if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestClientAuth(t *testing.T) {
+	ctx := context.Background()
+	srv := newMinifluxServer(t)
+	defer srv.Close()
+
+	if _, err := New(Config{BaseURL: srv.URL, Username: "admin", Password: "secret"}).Categories(ctx); err != nil {
+		t.Error("Basic auth failed:", err)
+	}
+	if _, err := New(Config{BaseURL: srv.URL, Token: "secret-token"}).Categories(ctx); err != nil {
+		t.Error("Token auth failed:", err)
+	}
+
+	_, err := New(Config{BaseURL: srv.URL, Token: "wrong"}).Categories(ctx)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Error("Wrong token should return ErrUnauthorized, got:", err)
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrServer) {
+		t.Error("Unauthorized error matches other kind of error")
+	}
+}
+
+func TestClientMethods(t *testing.T) {
+	ctx := context.Background()
+	srv := newMinifluxServer(t)
+	defer srv.Close()
+	c := New(Config{BaseURL: srv.URL + "/", Username: "admin", Password: "secret"})
+
+	categories, err := c.Categories(ctx)
+	if err != nil || len(categories) != 2 || categories[1].Title != "Bangli" {
+		t.Error("Wrong categories value:", categories, err)
+	}
+
+	feed, err := c.Feed(ctx, 2)
+	if err != nil || feed.ID != 2 || feed.Category.ID != 1 {
+		t.Error("Wrong feed value:", feed, err)
+	}
+
+	icon, err := c.FeedIcon(ctx, 2)
+	if err != nil || icon.MimeType != "image/png" {
+		t.Error("Wrong icon value:", icon, err)
+	}
+
+	entry, err := c.Entry(ctx, 1000)
+	if err != nil || entry.ID != 1000 || entry.Title != "Test Title" {
+		t.Error("Wrong entry value:", entry, err)
+	}
+
+	result, err := c.Entries(ctx, &EntryFilter{Status: "unread", After: 1556963965, CategoryID: 3, Limit: 50})
+	if err != nil || result.Total != 2 || len(result.Entries) != 2 {
+		t.Error("Wrong entries value:", result, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	srv := newMinifluxServer(t)
+	defer srv.Close()
+	c := New(Config{BaseURL: srv.URL, Username: "admin", Password: "secret"})
+
+	_, err := c.Entry(ctx, 404)
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("Missing entry should return ErrNotFound, got:", err)
+	}
+
+	_, err = c.Entry(ctx, 500)
+	if !errors.Is(err, ErrServer) {
+		t.Error("5xx should return ErrServer, got:", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != 500 || e.Message != "database error" {
+		t.Error("Wrong Error value:", err)
+	}
+
+	_, err = c.Entry(ctx, 1001)
+	if err == nil || errors.As(err, &e) {
+		t.Error("Broken JSON should return decode error, got:", err)
+	}
+}
@@ -0,0 +1,44 @@
+package miniflux
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNotFound is returned when the resource does not exist (404)
+	ErrNotFound = errors.New("miniflux: not found")
+	// ErrUnauthorized is returned when the credentials are rejected (401 or 403)
+	ErrUnauthorized = errors.New("miniflux: unauthorized")
+	// ErrServer is returned when Miniflux fails to process the request (5xx)
+	ErrServer = errors.New("miniflux: server error")
+)
+
+// Error is returned when Miniflux responds with non 200 status code.
+// Use errors.Is with ErrNotFound, ErrUnauthorized or ErrServer to check the kind of error.
+type Error struct {
+	StatusCode int
+	Path       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("miniflux: %s error status code: %v (%s)", e.Path, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("miniflux: %s error status code: %v", e.Path, e.StatusCode)
+}
+
+// Is reports whether Error matches one of the sentinel errors
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package miniflux
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling Miniflux when the circuit breaker is open
+var ErrCircuitOpen = errors.New("miniflux: circuit breaker is open")
+
+// IsRetryable reports whether err is transient and the request can be retried later,
+// eg. network errors, timeouts, 429, 5xx or open circuit breaker.
+// Not found, auth failures and malformed responses are permanent.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrServer) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// backoff returns exponential delay with jitter for given attempt (starts from 0)
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 || d > max {
+		d = max
+	}
+	// equal jitter: half fixed, half random
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// breaker is a simple circuit breaker,
+// it opens after threshold consecutive failures and let single trial request through after cooldown.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether request can be made
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	// open, only allow single trial after cooldown (half-open)
+	if !b.trial && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.trial = true
+		return true
+	}
+	return false
+}
+
+// record records request result, only retryable errors are counted as failure
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if !IsRetryable(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// getWithRetry calls do and retries retryable errors with backoff
+func (c *Client) getWithRetry(ctx context.Context, do func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.allow() {
+			return ErrCircuitOpen
+		}
+		err := do(ctx)
+		c.breaker.record(err)
+		if err == nil || !IsRetryable(err) || attempt >= c.maxRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff(attempt, c.retryDelay, c.maxRetryDelay)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
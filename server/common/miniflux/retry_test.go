@@ -0,0 +1,118 @@
+package miniflux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	retryable := []error{
+		&Error{StatusCode: 500},
+		&Error{StatusCode: 503},
+		&Error{StatusCode: 429},
+		ErrCircuitOpen,
+		context.DeadlineExceeded,
+	}
+	for _, err := range retryable {
+		if !IsRetryable(err) {
+			t.Errorf("%v should be retryable", err)
+		}
+	}
+
+	permanent := []error{
+		nil,
+		&Error{StatusCode: 404},
+		&Error{StatusCode: 401},
+		&Error{StatusCode: 400},
+		errors.New("miniflux: unable to decode response"),
+	}
+	for _, err := range permanent {
+		if IsRetryable(err) {
+			t.Errorf("%v should not be retryable", err)
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail twice then succeed
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer srv.Close()
+
+	c := New(Config{BaseURL: srv.URL, MaxRetries: 3, RetryDelay: time.Millisecond})
+	entry, err := c.Entry(context.Background(), 1)
+	if err != nil || entry.ID != 1 {
+		t.Error("Request should succeed after retries, got:", err)
+	}
+	if calls != 3 {
+		t.Errorf("Wrong number of calls: %v", calls)
+	}
+
+	// permanent error must not be retried
+	atomic.StoreInt32(&calls, 0)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if _, err := c.Entry(context.Background(), 1); !errors.Is(err, ErrNotFound) {
+		t.Error("Expecting ErrNotFound, got:", err)
+	}
+	if calls != 1 {
+		t.Errorf("Permanent error was retried: %v calls", calls)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := New(Config{BaseURL: srv.URL, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	c.Entry(ctx, 1)
+	c.Entry(ctx, 1)
+	if _, err := c.Entry(ctx, 1); err != ErrCircuitOpen {
+		t.Error("Expecting ErrCircuitOpen, got:", err)
+	}
+	if calls != 2 {
+		t.Errorf("Miniflux called while circuit is open: %v calls", calls)
+	}
+
+	// after cooldown only single trial request is allowed
+	now = now.Add(time.Hour)
+	if _, err := c.Entry(ctx, 1); !errors.Is(err, ErrServer) {
+		t.Error("Expecting trial request, got:", err)
+	}
+	if _, err := c.Entry(ctx, 1); err != ErrCircuitOpen {
+		t.Error("Failed trial should re-open the circuit, got:", err)
+	}
+
+	// successful trial closes the circuit
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1}`))
+	})
+	now = now.Add(time.Hour)
+	if _, err := c.Entry(ctx, 1); err != nil {
+		t.Error("Trial request should succeed, got:", err)
+	}
+	if _, err := c.Entry(ctx, 1); err != nil {
+		t.Error("Circuit should be closed, got:", err)
+	}
+}
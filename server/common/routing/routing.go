@@ -0,0 +1,230 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+
+	"server/common/constant"
+	"server/common/types"
+)
+
+const (
+	// IDMiniflux keys the entry document by Miniflux entry ID
+	IDMiniflux = "miniflux"
+	// IDPublishedAt keys the entry document by entry PublishedAt, entry.ID is replaced too
+	IDPublishedAt = "published_at"
+)
+
+// Route maps Miniflux categories or feeds into an entry collection
+type Route struct {
+	// Collection is the target entries collection
+	Collection string `json:"collection" firestore:"collection"`
+	// Title is the collection display name, eg. "Kriminal"
+	Title string `json:"title,omitempty" firestore:"title"`
+	// CategoryIDs and FeedIDs are the Miniflux category and feed IDs routed to Collection
+	CategoryIDs []int64 `json:"category_ids,omitempty" firestore:"category_ids"`
+	FeedIDs     []int64 `json:"feed_ids,omitempty" firestore:"feed_ids"`
+	// CategoryMin routes all categories with ID >= CategoryMin, 0 means disabled
+	CategoryMin int64 `json:"category_min,omitempty" firestore:"category_min"`
+	// IDStrategy is either IDMiniflux (default) or IDPublishedAt
+	IDStrategy string `json:"id_strategy,omitempty" firestore:"id_strategy"`
+}
+
+// match reports whether entry with categoryID and feedID belongs to this route
+func (r *Route) match(categoryID, feedID int64) bool {
+	return match(r.CategoryIDs, r.FeedIDs, r.CategoryMin, categoryID, feedID)
+}
+
+// Topic maps Miniflux categories or feeds into the category document whose subscribers get notified on new entry.
+// It's matched independently of Route, so entries of a feed can notify a topic other than their collection.
+type Topic struct {
+	// Topic is the category document ID, eg. "balebengong"
+	Topic string `json:"topic" firestore:"topic"`
+	// CategoryIDs, FeedIDs and CategoryMin are matched the same way as Route
+	CategoryIDs []int64 `json:"category_ids,omitempty" firestore:"category_ids"`
+	FeedIDs     []int64 `json:"feed_ids,omitempty" firestore:"feed_ids"`
+	CategoryMin int64   `json:"category_min,omitempty" firestore:"category_min"`
+}
+
+// match reports whether entry with categoryID and feedID notifies this topic
+func (r *Topic) match(categoryID, feedID int64) bool {
+	return match(r.CategoryIDs, r.FeedIDs, r.CategoryMin, categoryID, feedID)
+}
+
+// match reports whether entry with categoryID and feedID is listed in categoryIDs or feedIDs, or its category is at least categoryMin
+func match(categoryIDs, feedIDs []int64, categoryMin, categoryID, feedID int64) bool {
+	for _, id := range feedIDs {
+		if id == feedID {
+			return true
+		}
+	}
+	for _, id := range categoryIDs {
+		if id == categoryID {
+			return true
+		}
+	}
+	return categoryMin > 0 && categoryID >= categoryMin
+}
+
+// Table is the routing table, first matching route wins.
+// Entries that don't match any route go to Default.
+// Topics are looked up separately, entries that don't match any topic notify their own category.
+type Table struct {
+	Default Route   `json:"default" firestore:"default"`
+	Routes  []Route `json:"routes" firestore:"routes"`
+	Topics  []Topic `json:"topics,omitempty" firestore:"topics"`
+}
+
+// Default returns the BaliFeed routing table
+func Default() *Table {
+	return &Table{
+		Default: Route{Collection: constant.Entries, Title: "Berita"},
+		Routes: []Route{
+			{Collection: constant.Kriminal, Title: "Kriminal", CategoryIDs: []int64{11}, IDStrategy: IDPublishedAt},
+			{Collection: constant.BaliUnited, Title: "Bali United", CategoryIDs: []int64{12}, IDStrategy: IDPublishedAt},
+			{Collection: constant.BaleBengong, Title: "BaleBengong", CategoryMin: 13},
+		},
+		// BaleBengong subscribers subscribe on a separate category called "balebengong"
+		Topics: []Topic{
+			{Topic: constant.BaleBengong, FeedIDs: []int64{33, 34, 35, 36, 37, 38, 39, 40}},
+		},
+	}
+}
+
+// LoadFile loads routing table from JSON file
+func LoadFile(path string) (*Table, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Table
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("invalid routing file %s: %w", path, err)
+	}
+	return &t, t.validate()
+}
+
+// LoadFirestore loads routing table from Firestore document, eg. "config/routes"
+func LoadFirestore(ctx context.Context, client *firestore.Client, path string) (*Table, error) {
+	doc, err := client.Doc(path).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var t Table
+	if err := doc.DataTo(&t); err != nil {
+		return nil, fmt.Errorf("invalid routing document %s: %w", path, err)
+	}
+	return &t, t.validate()
+}
+
+// validate checks the table and fills default values
+func (t *Table) validate() error {
+	if t.Default.Collection == "" {
+		t.Default.Collection = constant.Entries
+	}
+	routes := append([]Route{t.Default}, t.Routes...)
+	for i, r := range routes {
+		if r.Collection == "" {
+			return fmt.Errorf("route #%d: collection is required", i)
+		}
+		if r.IDStrategy != "" && r.IDStrategy != IDMiniflux && r.IDStrategy != IDPublishedAt {
+			return fmt.Errorf("route #%d: unknown id_strategy %q", i, r.IDStrategy)
+		}
+	}
+	for i, r := range t.Topics {
+		if r.Topic == "" {
+			return fmt.Errorf("topic #%d: topic is required", i)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the route of entry with given category and feed ID
+func (t *Table) Lookup(categoryID, feedID int64) Route {
+	for _, r := range t.Routes {
+		if r.match(categoryID, feedID) {
+			return r
+		}
+	}
+	return t.Default
+}
+
+// Collection returns the entries collection of entry with given category and feed ID
+func (t *Table) Collection(categoryID, feedID int64) string {
+	return t.Lookup(categoryID, feedID).Collection
+}
+
+// Locate returns the collection and document ID of the entry,
+// entry.ID will be set to the document ID.
+func (t *Table) Locate(entry *types.Entry, minifluxID int64) (collection, id string) {
+	r := t.Lookup(entry.CategoryID, entry.FeedID)
+	if r.IDStrategy == IDPublishedAt {
+		entry.ID = entry.PublishedAt
+	} else {
+		entry.ID = minifluxID
+	}
+	return r.Collection, strconv.FormatInt(entry.ID, 10)
+}
+
+// SubscriberTopic returns the category document ID whose subscribers get notified,
+// first matching topic wins, default to the entry category.
+func (t *Table) SubscriberTopic(categoryID, feedID int64) string {
+	for _, r := range t.Topics {
+		if r.match(categoryID, feedID) {
+			return r.Topic
+		}
+	}
+	return strconv.FormatInt(categoryID, 10)
+}
+
+// CollectionInfo describes an entries collection and all categories and feeds routed to it
+type CollectionInfo struct {
+	Name        string  `json:"name"`
+	Title       string  `json:"title"`
+	CategoryIDs []int64 `json:"category_ids"`
+	FeedIDs     []int64 `json:"feed_ids"`
+	CategoryMin int64   `json:"category_min,omitempty"`
+}
+
+// Registry returns all entries collections, Default first.
+// Routes targeting the same collection are merged.
+func (t *Table) Registry() []*CollectionInfo {
+	var registry []*CollectionInfo
+	index := make(map[string]*CollectionInfo)
+	for _, r := range append([]Route{t.Default}, t.Routes...) {
+		c, ok := index[r.Collection]
+		if !ok {
+			c = &CollectionInfo{Name: r.Collection, Title: r.Title, CategoryIDs: []int64{}, FeedIDs: []int64{}}
+			index[r.Collection] = c
+			registry = append(registry, c)
+		}
+		if c.Title == "" {
+			c.Title = r.Title
+		}
+		c.CategoryIDs = append(c.CategoryIDs, r.CategoryIDs...)
+		c.FeedIDs = append(c.FeedIDs, r.FeedIDs...)
+		if r.CategoryMin > 0 && (c.CategoryMin == 0 || r.CategoryMin < c.CategoryMin) {
+			c.CategoryMin = r.CategoryMin
+		}
+	}
+	for _, c := range registry {
+		if c.Title == "" {
+			c.Title = c.Name
+		}
+	}
+	return registry
+}
+
+// Collections returns all entries collection names, Default first
+func (t *Table) Collections() []string {
+	var collections []string
+	for _, c := range t.Registry() {
+		collections = append(collections, c.Name)
+	}
+	return collections
+}
@@ -0,0 +1,48 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+
+	"server/common/types"
+)
+
+func TestLocate(t *testing.T) {
+	table := Default()
+	tests := []struct {
+		categoryID, feedID int64
+		collection, id     string
+		topic              string
+	}{
+		{1, 1, "entries", "100", "1"},
+		{11, 1, "kriminal", "5000", "11"},
+		{12, 1, "baliunited", "5000", "12"},
+		{13, 1, "balebengong", "100", "13"},
+		{5, 33, "entries", "100", "balebengong"},
+		{13, 33, "balebengong", "100", "balebengong"},
+	}
+	for _, tt := range tests {
+		entry := &types.Entry{CategoryID: tt.categoryID, FeedID: tt.feedID, PublishedAt: 5000}
+		collection, id := table.Locate(entry, 100)
+		if collection != tt.collection || id != tt.id {
+			t.Errorf("Locate(%v, %v) = %s/%s, want %s/%s", tt.categoryID, tt.feedID, collection, id, tt.collection, tt.id)
+		}
+		if topic := table.SubscriberTopic(tt.categoryID, tt.feedID); topic != tt.topic {
+			t.Errorf("SubscriberTopic(%v, %v) = %s, want %s", tt.categoryID, tt.feedID, topic, tt.topic)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	table, err := LoadFile("../../routes.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(table, Default()) {
+		t.Error("routes.example.json should match the default table")
+	}
+	want := []string{"entries", "kriminal", "baliunited", "balebengong"}
+	if got := table.Collections(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Collections() = %v, want %v", got, want)
+	}
+}
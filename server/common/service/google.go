@@ -7,6 +7,7 @@ import (
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
 	"firebase.google.com/go/messaging"
 )
 
@@ -18,10 +19,12 @@ type Google struct {
 	firestoreOnce sync.Once
 	messagingOnce sync.Once
 	pubsubOnce    sync.Once
+	authOnce      sync.Once
 
 	Firestore *firestore.Client
 	Messaging *messaging.Client
 	Pubsub    *pubsub.Client
+	Auth      *auth.Client
 }
 
 // InitFirestore initialize Firestore client
@@ -42,6 +45,15 @@ func (g *Google) InitMessaging(ctx context.Context) error {
 	return err
 }
 
+// InitAuth initialize Firebase Auth client
+func (g *Google) InitAuth(ctx context.Context) error {
+	var err error
+	g.authOnce.Do(func() {
+		g.Auth, err = g.firebaseApp.Auth(ctx)
+	})
+	return err
+}
+
 // InitPubsub initialize PubSub client
 func (g *Google) InitPubsub(ctx context.Context) error {
 	var err error
@@ -60,6 +72,46 @@ func (g *Google) PublishToTopic(ctx context.Context, topic string, msg *pubsub.M
 	return t.Publish(ctx, msg).Get(ctx)
 }
 
+// Send sends FCM message, messaging client is lazily initialized
+func (g *Google) Send(ctx context.Context, message *messaging.Message) (string, error) {
+	if err := g.InitMessaging(ctx); err != nil {
+		return "", err
+	}
+	return g.Messaging.Send(ctx, message)
+}
+
+// SendMulticast sends FCM message to multiple tokens, messaging client is lazily initialized
+func (g *Google) SendMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+	if err := g.InitMessaging(ctx); err != nil {
+		return nil, err
+	}
+	return g.Messaging.SendMulticast(ctx, message)
+}
+
+// SubscribeToTopic subscribes tokens to FCM topic, messaging client is lazily initialized
+func (g *Google) SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	if err := g.InitMessaging(ctx); err != nil {
+		return nil, err
+	}
+	return g.Messaging.SubscribeToTopic(ctx, tokens, topic)
+}
+
+// UnsubscribeFromTopic unsubscribes tokens from FCM topic, messaging client is lazily initialized
+func (g *Google) UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	if err := g.InitMessaging(ctx); err != nil {
+		return nil, err
+	}
+	return g.Messaging.UnsubscribeFromTopic(ctx, tokens, topic)
+}
+
+// VerifyIDToken verifies Firebase ID token, auth client is lazily initialized
+func (g *Google) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	if err := g.InitAuth(ctx); err != nil {
+		return nil, err
+	}
+	return g.Auth.VerifyIDToken(ctx, idToken)
+}
+
 // NewGoogle create new Firebase
 func NewGoogle(cxt context.Context, project string) (*Google, error) {
 	app, err := firebase.NewApp(context.Background(), nil)
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"firebase.google.com/go/auth"
+	"firebase.google.com/go/messaging"
+)
+
+// Local is a Messenger that only logs messages and a Verifier that trusts any token,
+// used to run the server locally without GCP credentials.
+type Local struct {
+	counter uint64
+}
+
+// NewLocal returns Local instance
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) nextID() string {
+	return fmt.Sprintf("local-%d", atomic.AddUint64(&l.counter, 1))
+}
+
+// Send logs the FCM message instead of sending it
+func (l *Local) Send(ctx context.Context, message *messaging.Message) (string, error) {
+	id := l.nextID()
+	title := ""
+	if message.Notification != nil {
+		title = message.Notification.Title
+	}
+	to := "token " + message.Token
+	if message.Topic != "" {
+		to = "topic " + message.Topic
+	}
+	log.Printf("[LOCAL] send %s to %s: %s\n", id, to, title)
+	return id, nil
+}
+
+// SendMulticast logs the FCM message for every token instead of sending it
+func (l *Local) SendMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+	br := &messaging.BatchResponse{}
+	for _, token := range message.Tokens {
+		id, _ := l.Send(ctx, &messaging.Message{Notification: message.Notification, Token: token})
+		br.Responses = append(br.Responses, &messaging.SendResponse{Success: true, MessageID: id})
+		br.SuccessCount++
+	}
+	return br, nil
+}
+
+// SubscribeToTopic logs the topic subscription
+func (l *Local) SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	log.Printf("[LOCAL] subscribe %d tokens to topic %s\n", len(tokens), topic)
+	return &messaging.TopicManagementResponse{SuccessCount: len(tokens)}, nil
+}
+
+// UnsubscribeFromTopic logs the topic unsubscription
+func (l *Local) UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	log.Printf("[LOCAL] unsubscribe %d tokens from topic %s\n", len(tokens), topic)
+	return &messaging.TopicManagementResponse{SuccessCount: len(tokens)}, nil
+}
+
+// VerifyIDToken accepts any token, the token itself is used as user ID
+func (l *Local) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	return &auth.Token{UID: idToken, Subject: idToken, Claims: map[string]interface{}{}}, nil
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+
+	"firebase.google.com/go/auth"
+	"firebase.google.com/go/messaging"
+)
+
+// Messenger sends push notification message
+type Messenger interface {
+	Send(ctx context.Context, message *messaging.Message) (string, error)
+	// SendMulticast sends message to up to 500 tokens, responses are in the same order as the tokens
+	SendMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error)
+	// SubscribeToTopic and UnsubscribeFromTopic manage FCM topic membership of up to 1000 tokens
+	SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+	UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+}
+
+// Verifier verifies Firebase ID token of app users
+type Verifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
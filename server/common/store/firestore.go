@@ -0,0 +1,1161 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	fs "cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"server/common/constant"
+	"server/common/service"
+	"server/common/types"
+)
+
+// Firestore is a Store backed by Cloud Firestore
+type Firestore struct {
+	google *service.Google
+}
+
+// NewFirestore returns Firestore store instance
+func NewFirestore(google *service.Google) *Firestore {
+	return &Firestore{google}
+}
+
+// client returns Firestore client (lazily initialized)
+func (s *Firestore) client(ctx context.Context) (*fs.Client, error) {
+	if err := s.google.InitFirestore(ctx); err != nil {
+		return nil, err
+	}
+	return s.google.Firestore, nil
+}
+
+// notFound translates Firestore NotFound error into ErrNotFound
+func notFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GetCategory returns single category
+func (s *Firestore) GetCategory(ctx context.Context, id string) (*types.Category, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := client.Collection(constant.Categories).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var category types.Category
+	if err := doc.DataTo(&category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// ListCategories returns all categories
+func (s *Firestore) ListCategories(ctx context.Context) ([]types.Category, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := client.Collection(constant.Categories).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var categories []types.Category
+	for _, snap := range snaps {
+		var category types.Category
+		if err := snap.DataTo(&category); err != nil {
+			continue
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+// SaveCategories creates or replaces categories in a batch
+func (s *Firestore) SaveCategories(ctx context.Context, categories []types.Category) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	batch := client.Batch()
+	for _, cat := range categories {
+		docRef := client.Collection(constant.Categories).Doc(strconv.FormatInt(cat.ID, 10))
+		batch.Set(docRef, cat)
+	}
+	_, err = batch.Commit(ctx)
+	return err
+}
+
+// DeleteCategory deletes single category
+func (s *Firestore) DeleteCategory(ctx context.Context, id int64) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.Categories).Doc(strconv.FormatInt(id, 10)).Delete(ctx)
+	return err
+}
+
+// ListFeeds returns all feeds
+func (s *Firestore) ListFeeds(ctx context.Context) ([]types.Feed, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iter := client.Collection(constant.Feeds).Documents(ctx)
+	var feeds []types.Feed
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		var feed types.Feed
+		if err := doc.DataTo(&feed); err != nil {
+			continue
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
+
+// SaveFeed creates or replaces a feed
+func (s *Firestore) SaveFeed(ctx context.Context, feed *types.Feed) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.Feeds).Doc(strconv.FormatInt(feed.ID, 10)).Set(ctx, feed)
+	return err
+}
+
+// DeleteFeed deletes single feed
+func (s *Firestore) DeleteFeed(ctx context.Context, id int64) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.Feeds).Doc(strconv.FormatInt(id, 10)).Delete(ctx)
+	return err
+}
+
+// GetEntry returns single entry of a collection
+func (s *Firestore) GetEntry(ctx context.Context, collection, id string) (map[string]interface{}, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := client.Collection(collection).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return doc.Data(), nil
+}
+
+// ListEntries returns entries ordered by published_at descending
+func (s *Firestore) ListEntries(ctx context.Context, q EntryQuery) ([]map[string]interface{}, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(q.Collection).OrderBy("published_at", fs.Desc).Limit(q.Limit)
+	if q.Cursor > 0 {
+		query = query.StartAfter(q.Cursor)
+	}
+	if q.CategoryID > 0 {
+		query = query.Where("category_id", "==", q.CategoryID)
+	}
+
+	iter := query.Documents(ctx)
+	var items []map[string]interface{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		items = append(items, doc.Data())
+	}
+	return items, nil
+}
+
+// SaveEntry creates or replaces an entry of a collection,
+// new entry is counted in the collection stats.
+func (s *Firestore) SaveEntry(ctx context.Context, collection, id string, entry *types.Entry) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	ref := client.Collection(collection).Doc(id)
+
+	// the batch fails as a whole when the entry exists, so it's counted once
+	batch := client.Batch()
+	batch.Create(ref, entry)
+	batch.Set(entryStats(client, collection), map[string]interface{}{"entry_count": fs.Increment(1)}, fs.MergeAll)
+	_, err = batch.Commit(ctx)
+	if status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	_, err = ref.Set(ctx, entry)
+	return err
+}
+
+// entryStats returns the document counting entries of collection, Firestore has no count query
+func entryStats(client *fs.Client, collection string) *fs.DocumentRef {
+	return client.Collection(constant.CollectionStats).Doc(collection)
+}
+
+// CountEntries returns number of entries in a collection from the collection stats.
+// Entries are counted once when the stats are missing (written before they were added).
+func (s *Firestore) CountEntries(ctx context.Context, collection string) (int, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	doc, err := entryStats(client, collection).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return 0, err
+	}
+	if err == nil {
+		if counted, _ := doc.DataAt("counted"); counted == true {
+			count, err := doc.DataAt("entry_count")
+			if err != nil {
+				return 0, err
+			}
+			n, _ := count.(int64)
+			return int(n), nil
+		}
+	}
+
+	// only document references are fetched
+	iter := client.Collection(collection).Select().Documents(ctx)
+	defer iter.Stop()
+	count := 0
+	for {
+		_, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	_, err = entryStats(client, collection).Set(ctx, map[string]interface{}{"entry_count": count, "counted": true})
+	return count, err
+}
+
+// DeleteEntry deletes single entry of a collection and uncounts it from the collection stats
+func (s *Firestore) DeleteEntry(ctx context.Context, collection, id string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the batch fails as a whole when the entry doesn't exist, so it's uncounted once
+	batch := client.Batch()
+	batch.Delete(client.Collection(collection).Doc(id), fs.Exists)
+	batch.Set(entryStats(client, collection), map[string]interface{}{"entry_count": fs.Increment(-1)}, fs.MergeAll)
+	_, err = batch.Commit(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+// GetEntryRef returns the location of entry with given Miniflux ID
+func (s *Firestore) GetEntryRef(ctx context.Context, minifluxID int64) (*EntryRef, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := client.Collection(constant.EntryIndex).Doc(strconv.FormatInt(minifluxID, 10)).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var ref EntryRef
+	if err := doc.DataTo(&ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// SaveEntryRef creates or replaces the location of entry with given Miniflux ID
+func (s *Firestore) SaveEntryRef(ctx context.Context, minifluxID int64, ref *EntryRef) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.EntryIndex).Doc(strconv.FormatInt(minifluxID, 10)).Set(ctx, ref)
+	return err
+}
+
+// DeleteEntryRef deletes the location of entry with given Miniflux ID
+func (s *Firestore) DeleteEntryRef(ctx context.Context, minifluxID int64) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.EntryIndex).Doc(strconv.FormatInt(minifluxID, 10)).Delete(ctx)
+	return err
+}
+
+// GetResponse returns single entry response
+func (s *Firestore) GetResponse(ctx context.Context, id string) (*types.Response, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := client.Collection(constant.EntryResponses).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var r types.Response
+	if err := doc.DataTo(&r); err != nil {
+		return nil, err
+	}
+	r.ID = doc.Ref.ID
+	return &r, nil
+}
+
+// SaveResponse creates or replaces an entry response
+func (s *Firestore) SaveResponse(ctx context.Context, r *types.Response) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.EntryResponses).Doc(r.ID).Set(ctx, r)
+	return err
+}
+
+// CreateResponse creates an entry response, returns ErrAlreadyExists when its ID is taken
+func (s *Firestore) CreateResponse(ctx context.Context, r *types.Response) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.EntryResponses).Doc(r.ID).Create(ctx, r)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// UpdateResponse sets top level fields of an entry response, other fields are kept
+func (s *Firestore) UpdateResponse(ctx context.Context, id string, fields map[string]interface{}) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	updates := make([]fs.Update, 0, len(fields))
+	for name, value := range fields {
+		updates = append(updates, fs.Update{Path: name, Value: value})
+	}
+	_, err = client.Collection(constant.EntryResponses).Doc(id).Update(ctx, updates)
+	return notFound(err)
+}
+
+// ListResponses returns entry responses matching the query
+func (s *Firestore) ListResponses(ctx context.Context, q ResponseQuery) ([]types.Response, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(constant.EntryResponses).Query
+	if q.ThreadID != "" {
+		query = query.Where("thread_id", "==", q.ThreadID)
+	}
+	if q.ParentID != "" {
+		query = query.Where("parent_id", "==", q.ParentID)
+	}
+	if q.EntryID != 0 {
+		query = query.Where("entry_id", "==", q.EntryID)
+	}
+	if q.UserID != "" {
+		query = query.Where("user_id", "==", q.UserID)
+	}
+	if q.Type != "" {
+		query = query.Where("type", "==", q.Type)
+	}
+	return responseDocuments(ctx, query)
+}
+
+// ListComments returns top level comments of an entry, newest first.
+// Comments without created_at are not listed, see BackfillResponses.
+func (s *Firestore) ListComments(ctx context.Context, q CommentQuery) ([]types.Response, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(constant.EntryResponses).
+		Where("entry_id", "==", q.EntryID).
+		Where("type", "==", "COMMENT").
+		Where("thread_id", "==", "")
+	if q.CursorID != "" {
+		query = query.OrderBy("created_at", fs.Desc).OrderBy(fs.DocumentID, fs.Desc).StartAfter(q.Cursor, q.CursorID)
+	} else {
+		if q.Cursor > 0 {
+			query = query.Where("created_at", "<", q.Cursor)
+		}
+		query = query.OrderBy("created_at", fs.Desc).OrderBy(fs.DocumentID, fs.Desc)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	return responseDocuments(ctx, query)
+}
+
+// ListReplies returns comments in a thread, oldest first.
+// Replies without created_at are not listed, see BackfillResponses.
+func (s *Firestore) ListReplies(ctx context.Context, q ReplyQuery) ([]types.Response, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(constant.EntryResponses).
+		Where("thread_id", "==", q.ThreadID).
+		Where("type", "==", "COMMENT").
+		OrderBy("created_at", fs.Asc).
+		OrderBy(fs.DocumentID, fs.Asc)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	return responseDocuments(ctx, query)
+}
+
+// responseDocuments returns entry responses of the query
+func responseDocuments(ctx context.Context, query fs.Query) ([]types.Response, error) {
+	snaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]types.Response, 0, len(snaps))
+	for _, snap := range snaps {
+		var r types.Response
+		if err := snap.DataTo(&r); err != nil {
+			return nil, err
+		}
+		r.ID = snap.Ref.ID
+		responses = append(responses, r)
+	}
+	return responses, nil
+}
+
+// BackfillResponses sets created_at of entry responses written before it was added
+// to their document create time, so they are listed by ListComments and ListReplies.
+// Returns number of updated responses.
+func (s *Firestore) BackfillResponses(ctx context.Context) (int, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return 0, err
+	}
+	snaps, err := client.Collection(constant.EntryResponses).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	var refs []*fs.DocumentRef
+	createdAt := make(map[string]int64)
+	for _, snap := range snaps {
+		if _, err := snap.DataAt("created_at"); err == nil {
+			continue
+		}
+		refs = append(refs, snap.Ref)
+		createdAt[snap.Ref.ID] = snap.CreateTime.UnixNano() / int64(time.Millisecond)
+	}
+	err = commitBatches(ctx, client, refs, func(batch *fs.WriteBatch, ref *fs.DocumentRef) {
+		batch.Update(ref, []fs.Update{{Path: "created_at", Value: createdAt[ref.ID]}})
+	})
+	return len(refs), err
+}
+
+// DeleteResponses deletes entry responses in batches of 500 writes
+func (s *Firestore) DeleteResponses(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	refs := make([]*fs.DocumentRef, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, client.Collection(constant.EntryResponses).Doc(id))
+	}
+	return commitBatches(ctx, client, refs, func(batch *fs.WriteBatch, ref *fs.DocumentRef) {
+		batch.Delete(ref)
+	})
+}
+
+// ListSubscribers returns subscribers of a category
+func (s *Firestore) ListSubscribers(ctx context.Context, categoryID string) ([]types.Subscriber, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iter := client.Collection(fmt.Sprintf("%s/%v/subscribers", constant.Categories, categoryID)).Documents(ctx)
+	var subscribers []types.Subscriber
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		var sub types.Subscriber
+		if err := doc.DataTo(&sub); err != nil {
+			continue
+		}
+		sub.ID = doc.Ref.ID
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers, nil
+}
+
+// DeleteSubscriber removes a subscriber from a category
+func (s *Firestore) DeleteSubscriber(ctx context.Context, categoryID, subscriberID string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(fmt.Sprintf("%s/%v/subscribers", constant.Categories, categoryID)).Doc(subscriberID).Delete(ctx)
+	return err
+}
+
+// topicUser is the record of user subscribed to FCM topic
+type topicUser struct {
+	Topic  string `firestore:"topic"`
+	UserID string `firestore:"user_id"`
+}
+
+// ListTopicUsers returns IDs of users whose devices are subscribed to FCM topic
+func (s *Firestore) ListTopicUsers(ctx context.Context, topic string) ([]string, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := client.Collection(constant.TopicUsers).Where("topic", "==", topic).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(snaps))
+	for _, snap := range snaps {
+		var rec topicUser
+		if err := snap.DataTo(&rec); err != nil {
+			return nil, err
+		}
+		ids = append(ids, rec.UserID)
+	}
+	return ids, nil
+}
+
+// SetTopicUser records the user whose devices are subscribed to FCM topic, or removes it when not subscribed
+func (s *Firestore) SetTopicUser(ctx context.Context, topic, userID string, subscribed bool) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	ref := client.Collection(constant.TopicUsers).Doc(topic + "_" + userID)
+	if !subscribed {
+		_, err = ref.Delete(ctx)
+		return err
+	}
+	_, err = ref.Set(ctx, topicUser{Topic: topic, UserID: userID})
+	return err
+}
+
+// GetUser returns single user
+func (s *Firestore) GetUser(ctx context.Context, id string) (*types.User, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := client.Collection(constant.Users).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var user types.User
+	if err := doc.DataTo(&user); err != nil {
+		return nil, err
+	}
+	user.ID = doc.Ref.ID
+	return &user, nil
+}
+
+// SetUserTokens replaces user's FCM tokens
+func (s *Firestore) SetUserTokens(ctx context.Context, id string, tokens map[string]interface{}) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.Users).Doc(id).Update(ctx, []fs.Update{{Path: "fcm_tokens", Value: tokens}})
+	return err
+}
+
+// ClaimMessage records message id in the processed-message ledger
+func (s *Firestore) ClaimMessage(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return claimMessage(ctx, s, id, ttl)
+}
+
+// ReleaseMessage removes message id from the processed-message ledger
+func (s *Firestore) ReleaseMessage(ctx context.Context, id string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.ProcessedMessages).Doc(id).Delete(ctx)
+	return err
+}
+
+// attemptsRecord is the delivery attempts counter document
+type attemptsRecord struct {
+	Attempts  int       `firestore:"attempts"`
+	ExpiresAt time.Time `firestore:"expires_at"`
+}
+
+// IncrementAttempts increments and returns delivery attempts of message id
+func (s *Firestore) IncrementAttempts(ctx context.Context, id string, ttl time.Duration) (int, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ref := client.Collection(constant.MessageAttempts).Doc(id)
+
+	var attempts int
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *fs.Transaction) error {
+		var rec attemptsRecord
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&rec); err != nil {
+				return err
+			}
+		}
+		if rec.ExpiresAt.Before(time.Now()) {
+			rec.Attempts = 0
+		}
+		attempts = rec.Attempts + 1
+		return tx.Set(ref, attemptsRecord{Attempts: attempts, ExpiresAt: time.Now().Add(ttl)})
+	})
+	return attempts, err
+}
+
+// IncrementCounter increments and returns the counter of key within a fixed window
+func (s *Firestore) IncrementCounter(ctx context.Context, key string, window time.Duration) (int, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ref := client.Collection(constant.Counters).Doc(key)
+
+	var count int
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *fs.Transaction) error {
+		var rec attemptsRecord
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&rec); err != nil {
+				return err
+			}
+		}
+		if rec.ExpiresAt.Before(time.Now()) {
+			rec = attemptsRecord{ExpiresAt: time.Now().Add(window)}
+		}
+		count = rec.Attempts + 1
+		return tx.Set(ref, attemptsRecord{Attempts: count, ExpiresAt: rec.ExpiresAt})
+	})
+	return count, err
+}
+
+// SaveDeadLetter parks the failed message
+func (s *Firestore) SaveDeadLetter(ctx context.Context, msg *types.DeadLetter) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.DeadLetters).Doc(msg.ID).Set(ctx, msg)
+	return err
+}
+
+// GetDeadLetter returns single parked message
+func (s *Firestore) GetDeadLetter(ctx context.Context, id string) (*types.DeadLetter, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := client.Collection(constant.DeadLetters).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var msg types.DeadLetter
+	if err := doc.DataTo(&msg); err != nil {
+		return nil, err
+	}
+	msg.ID = doc.Ref.ID
+	return &msg, nil
+}
+
+// ListDeadLetters returns parked messages, filtering by path requires composite index (path, created_at desc)
+func (s *Firestore) ListDeadLetters(ctx context.Context, q DeadLetterQuery) ([]types.DeadLetter, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(constant.DeadLetters).OrderBy("created_at", fs.Desc)
+	if q.Path != "" {
+		query = query.Where("path", "==", q.Path)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	snaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]types.DeadLetter, 0, len(snaps))
+	for _, snap := range snaps {
+		var msg types.DeadLetter
+		if err := snap.DataTo(&msg); err != nil {
+			return nil, err
+		}
+		msg.ID = snap.Ref.ID
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// DeleteDeadLetter deletes single parked message
+func (s *Firestore) DeleteDeadLetter(ctx context.Context, id string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.DeadLetters).Doc(id).Delete(ctx)
+	return err
+}
+
+// PurgeDeadLetters deletes messages parked before given time
+func (s *Firestore) PurgeDeadLetters(ctx context.Context, before time.Time) (int, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	snaps, err := client.Collection(constant.DeadLetters).Where("created_at", "<", before).Select().Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	// batch is limited to 500 writes
+	for i := 0; i < len(snaps); i += 500 {
+		end := i + 500
+		if end > len(snaps) {
+			end = len(snaps)
+		}
+		batch := client.Batch()
+		for _, snap := range snaps[i:end] {
+			batch.Delete(snap.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return i, err
+		}
+	}
+	return len(snaps), nil
+}
+
+// SavePendingNotification holds notification until its DueAt
+func (s *Firestore) SavePendingNotification(ctx context.Context, n *types.PendingNotification) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.PendingNotifications).Doc(n.ID).Set(ctx, n)
+	return err
+}
+
+// ListDuePendingNotifications returns notifications due at or before given time, oldest first
+func (s *Firestore) ListDuePendingNotifications(ctx context.Context, before time.Time, limit int) ([]types.PendingNotification, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(constant.PendingNotifications).Where("due_at", "<=", before).OrderBy("due_at", fs.Asc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	snaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	notifications := make([]types.PendingNotification, 0, len(snaps))
+	for _, snap := range snaps {
+		var n types.PendingNotification
+		if err := snap.DataTo(&n); err != nil {
+			return nil, err
+		}
+		n.ID = snap.Ref.ID
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// DeletePendingNotification deletes single pending notification
+func (s *Firestore) DeletePendingNotification(ctx context.Context, id string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(constant.PendingNotifications).Doc(id).Delete(ctx)
+	return err
+}
+
+// notifications returns the inbox collection of user
+func notifications(client *fs.Client, userID string) *fs.CollectionRef {
+	return client.Collection(constant.Users).Doc(userID).Collection(constant.Notifications)
+}
+
+// AddNotification adds notification to user's inbox
+func (s *Firestore) AddNotification(ctx context.Context, userID string, n *types.Notification) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = notifications(client, userID).Doc(n.ID).Create(ctx, n)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// ListNotifications returns user's inbox notifications, newest first
+func (s *Firestore) ListNotifications(ctx context.Context, q NotificationQuery) ([]types.Notification, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := notifications(client, q.UserID).OrderBy("created_at", fs.Desc).OrderBy(fs.DocumentID, fs.Desc)
+	if q.UnreadOnly {
+		query = query.Where("read", "==", false)
+	}
+	if q.CursorID != "" {
+		// created_at is stored in microsecs, start after the cursor document itself when it still exists
+		snap, err := notifications(client, q.UserID).Doc(q.CursorID).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+		if err == nil {
+			query = query.StartAfter(snap)
+		} else {
+			query = query.StartAfter(time.Unix(0, q.Cursor*int64(time.Millisecond)), q.CursorID)
+		}
+	} else if q.Cursor > 0 {
+		query = query.Where("created_at", "<", time.Unix(0, q.Cursor*int64(time.Millisecond)))
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	snaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]types.Notification, 0, len(snaps))
+	for _, snap := range snaps {
+		var n types.Notification
+		if err := snap.DataTo(&n); err != nil {
+			return nil, err
+		}
+		n.ID = snap.Ref.ID
+		items = append(items, n)
+	}
+	return items, nil
+}
+
+// CountUnreadNotifications returns number of unread notifications in user's inbox
+func (s *Firestore) CountUnreadNotifications(ctx context.Context, userID string) (int, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return 0, err
+	}
+	snaps, err := notifications(client, userID).Where("read", "==", false).Select().Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	return len(snaps), nil
+}
+
+// MarkNotificationsRead marks notifications as read, empty ids means all user's notifications
+func (s *Firestore) MarkNotificationsRead(ctx context.Context, userID string, ids []string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	refs, err := notificationRefs(ctx, client, userID, ids, notifications(client, userID).Where("read", "==", false))
+	if err != nil {
+		return err
+	}
+	return commitBatches(ctx, client, refs, func(batch *fs.WriteBatch, ref *fs.DocumentRef) {
+		batch.Update(ref, []fs.Update{{Path: "read", Value: true}})
+	})
+}
+
+// DeleteNotifications deletes notifications from user's inbox, empty ids means all user's notifications
+func (s *Firestore) DeleteNotifications(ctx context.Context, userID string, ids []string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	refs, err := notificationRefs(ctx, client, userID, ids, notifications(client, userID).Query)
+	if err != nil {
+		return err
+	}
+	return commitBatches(ctx, client, refs, func(batch *fs.WriteBatch, ref *fs.DocumentRef) {
+		batch.Delete(ref)
+	})
+}
+
+// notificationRefs returns refs of existing notifications with given ids, or all matching the query when ids is empty
+func notificationRefs(ctx context.Context, client *fs.Client, userID string, ids []string, all fs.Query) ([]*fs.DocumentRef, error) {
+	var snaps []*fs.DocumentSnapshot
+	var err error
+	if len(ids) == 0 {
+		snaps, err = all.Select().Documents(ctx).GetAll()
+	} else {
+		refs := make([]*fs.DocumentRef, 0, len(ids))
+		for _, id := range ids {
+			refs = append(refs, notifications(client, userID).Doc(id))
+		}
+		snaps, err = client.GetAll(ctx, refs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*fs.DocumentRef
+	for _, snap := range snaps {
+		if snap.Exists() {
+			refs = append(refs, snap.Ref)
+		}
+	}
+	return refs, nil
+}
+
+// commitBatches applies write to refs in batches, batch is limited to 500 writes
+func commitBatches(ctx context.Context, client *fs.Client, refs []*fs.DocumentRef, write func(*fs.WriteBatch, *fs.DocumentRef)) error {
+	for i := 0; i < len(refs); i += 500 {
+		end := i + 500
+		if end > len(refs) {
+			end = len(refs)
+		}
+		batch := client.Batch()
+		for _, ref := range refs[i:end] {
+			write(batch, ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordDelivery saves delivery record and adds it to the daily stats
+func (s *Firestore) RecordDelivery(ctx context.Context, day string, d *types.Delivery) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	batch := client.Batch()
+	batch.Set(client.Collection(constant.Deliveries).Doc(d.ID), d)
+	batch.Set(client.Collection(constant.DeliveryStats).Doc(statsID(day, d)), map[string]interface{}{
+		"day":           day,
+		"data_type":     d.DataType,
+		"category":      d.Category,
+		"notifications": fs.Increment(1),
+		"tokens":        fs.Increment(d.Tokens),
+		"sent":          fs.Increment(d.Sent),
+		"pruned":        fs.Increment(d.Pruned),
+		"failed":        fs.Increment(d.Failed),
+	}, fs.MergeAll)
+	_, err = batch.Commit(ctx)
+	return err
+}
+
+// statsID returns daily stats document ID of delivery, eg. "2020-01-10_entry_13"
+func statsID(day string, d *types.Delivery) string {
+	return day + "_" + d.DataType + "_" + d.Category
+}
+
+// ListDeliveries returns delivery records, newest first
+func (s *Firestore) ListDeliveries(ctx context.Context, q DeliveryQuery) ([]types.Delivery, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.Collection(constant.Deliveries).OrderBy("created_at", fs.Desc)
+	if q.UserID != "" {
+		query = query.Where("user_id", "==", q.UserID)
+	}
+	if q.EntryID != "" {
+		query = query.Where("entry_id", "==", q.EntryID)
+	}
+	if q.ResponseID != "" {
+		query = query.Where("response_id", "==", q.ResponseID)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	snaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]types.Delivery, 0, len(snaps))
+	for _, snap := range snaps {
+		var d types.Delivery
+		if err := snap.DataTo(&d); err != nil {
+			return nil, err
+		}
+		d.ID = snap.Ref.ID
+		items = append(items, d)
+	}
+	return items, nil
+}
+
+// ListDeliveryStats returns daily delivery stats from day to day
+func (s *Firestore) ListDeliveryStats(ctx context.Context, from, to string) ([]types.DeliveryStats, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snaps, err := client.Collection(constant.DeliveryStats).Where("day", ">=", from).Where("day", "<=", to).OrderBy("day", fs.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]types.DeliveryStats, 0, len(snaps))
+	for _, snap := range snaps {
+		var stats types.DeliveryStats
+		if err := snap.DataTo(&stats); err != nil {
+			return nil, err
+		}
+		items = append(items, stats)
+	}
+	return items, nil
+}
+
+// RunTransaction runs fn inside Firestore transaction
+func (s *Firestore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *fs.Transaction) error {
+		ftx := &firestoreTx{client: client, tx: tx}
+		if err := fn(ctx, ftx); err != nil {
+			return err
+		}
+		return ftx.flush()
+	})
+	// updating missing document only fails on commit
+	return notFound(err)
+}
+
+// firestoreTx implements Tx
+type firestoreTx struct {
+	client *fs.Client
+	tx     *fs.Transaction
+	claims []func() error // ledger writes, deferred so reads can still be done after ClaimMessage
+}
+
+// flush writes the deferred ledger records
+func (t *firestoreTx) flush() error {
+	for _, claim := range t.claims {
+		if err := claim(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *firestoreTx) GetResponse(id string) (*types.Response, error) {
+	doc, err := t.tx.Get(t.client.Collection(constant.EntryResponses).Doc(id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var r types.Response
+	if err := doc.DataTo(&r); err != nil {
+		return nil, err
+	}
+	r.ID = doc.Ref.ID
+	return &r, nil
+}
+
+func (t *firestoreTx) IncrementEntry(collection, id string, counters map[string]int) error {
+	return t.tx.Update(t.client.Collection(collection).Doc(id), incrementUpdates(counters))
+}
+
+func (t *firestoreTx) IncrementResponse(id string, counters map[string]int) error {
+	return t.tx.Update(t.client.Collection(constant.EntryResponses).Doc(id), incrementUpdates(counters))
+}
+
+// ledgerRecord is the processed-message ledger document,
+// set Firestore TTL policy on expires_at to clean up expired records.
+type ledgerRecord struct {
+	ExpiresAt time.Time `firestore:"expires_at"`
+}
+
+func (t *firestoreTx) ClaimMessage(id string, ttl time.Duration) (bool, error) {
+	ref := t.client.Collection(constant.ProcessedMessages).Doc(id)
+	doc, err := t.tx.Get(ref)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return false, err
+	}
+	if err == nil {
+		var rec ledgerRecord
+		if err := doc.DataTo(&rec); err != nil {
+			return false, err
+		}
+		if rec.ExpiresAt.After(time.Now()) {
+			return false, nil
+		}
+	}
+	t.claims = append(t.claims, func() error {
+		return t.tx.Set(ref, ledgerRecord{ExpiresAt: time.Now().Add(ttl)})
+	})
+	return true, nil
+}
+
+// incrementUpdates converts counters into Firestore increment updates
+func incrementUpdates(counters map[string]int) []fs.Update {
+	var updates []fs.Update
+	for field, value := range counters {
+		updates = append(updates, fs.Update{Path: field, Value: fs.Increment(value)})
+	}
+	return updates
+}
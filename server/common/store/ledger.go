@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicate is returned when the message is already in the processed-message ledger
+var ErrDuplicate = errors.New("store: message already processed")
+
+// Once calls fn only when message id hasn't been processed yet and records it in the ledger for ttl.
+// The record is removed when fn fails so the redelivered message can be processed again.
+// Returns ErrDuplicate without calling fn when the message has been processed.
+func Once(ctx context.Context, s Store, id string, ttl time.Duration, fn func() error) error {
+	claimed, err := s.ClaimMessage(ctx, id, ttl)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrDuplicate
+	}
+	if err := fn(); err != nil {
+		if rerr := s.ReleaseMessage(ctx, id); rerr != nil {
+			return fmt.Errorf("%w (unable to release message %s: %v)", err, id, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// claimMessage runs Tx.ClaimMessage in its own transaction
+func claimMessage(ctx context.Context, s Store, id string, ttl time.Duration) (bool, error) {
+	claimed := false
+	err := s.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		claimed, err = tx.ClaimMessage(id, ttl)
+		return err
+	})
+	return claimed, err
+}
@@ -0,0 +1,1003 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/common/types"
+)
+
+// Memory is a concurrency-safe in-memory Store, useful for local development and tests.
+// Documents are kept as maps (the same shape as Firestore documents)
+// so aggregated counters like comment_count can live next to the entry fields.
+type Memory struct {
+	mu            sync.RWMutex
+	categories    map[string]map[string]interface{}
+	feeds         map[string]map[string]interface{}
+	entries       map[string]map[string]map[string]interface{} // collection -> id -> doc
+	entryIndex    map[int64]EntryRef
+	responses     map[string]map[string]interface{}
+	subscribers   map[string]map[string]map[string]interface{} // category -> id -> doc
+	topicUsers    map[string]map[string]bool                   // topic -> user ids
+	users         map[string]map[string]interface{}
+	processed     map[string]time.Time // message id -> expires at
+	attempts      map[string]attempts
+	counters      map[string]attempts
+	deadLetters   map[string]map[string]interface{}
+	pending       map[string]map[string]interface{}
+	notifications map[string]map[string]map[string]interface{} // user -> id -> doc
+	deliveries    map[string]map[string]interface{}
+	deliveryStats map[string]*types.DeliveryStats // day_type_category -> stats
+}
+
+// attempts is the delivery attempts counter of a message, also used for fixed window counters
+type attempts struct {
+	count     int
+	expiresAt time.Time
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{
+		categories:    make(map[string]map[string]interface{}),
+		feeds:         make(map[string]map[string]interface{}),
+		entries:       make(map[string]map[string]map[string]interface{}),
+		entryIndex:    make(map[int64]EntryRef),
+		responses:     make(map[string]map[string]interface{}),
+		subscribers:   make(map[string]map[string]map[string]interface{}),
+		topicUsers:    make(map[string]map[string]bool),
+		users:         make(map[string]map[string]interface{}),
+		processed:     make(map[string]time.Time),
+		attempts:      make(map[string]attempts),
+		counters:      make(map[string]attempts),
+		deadLetters:   make(map[string]map[string]interface{}),
+		pending:       make(map[string]map[string]interface{}),
+		notifications: make(map[string]map[string]map[string]interface{}),
+		deliveries:    make(map[string]map[string]interface{}),
+		deliveryStats: make(map[string]*types.DeliveryStats),
+	}
+}
+
+// toDoc converts struct into document map using its json tags
+func toDoc(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// fromDoc converts document map into struct pointed by v
+func fromDoc(doc map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// copyDoc returns shallow copy of a document so callers can't mutate stored data
+func copyDoc(doc map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		c[k] = v
+	}
+	return c
+}
+
+// toInt64 returns numeric document value as int64
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// increment applies counters to doc
+func increment(doc map[string]interface{}, counters map[string]int) {
+	for field, value := range counters {
+		doc[field] = toInt64(doc[field]) + int64(value)
+	}
+}
+
+// GetCategory returns single category
+func (m *Memory) GetCategory(ctx context.Context, id string) (*types.Category, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, ok := m.categories[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var category types.Category
+	if err := fromDoc(doc, &category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// ListCategories returns all categories ordered by ID
+func (m *Memory) ListCategories(ctx context.Context) ([]types.Category, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var categories []types.Category
+	for _, doc := range m.categories {
+		var category types.Category
+		if err := fromDoc(doc, &category); err != nil {
+			continue
+		}
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
+// SaveCategories creates or replaces categories
+func (m *Memory) SaveCategories(ctx context.Context, categories []types.Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, cat := range categories {
+		doc, err := toDoc(cat)
+		if err != nil {
+			return err
+		}
+		m.categories[strconv.FormatInt(cat.ID, 10)] = doc
+	}
+	return nil
+}
+
+// DeleteCategory deletes single category
+func (m *Memory) DeleteCategory(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.categories, strconv.FormatInt(id, 10))
+	return nil
+}
+
+// ListFeeds returns all feeds ordered by ID
+func (m *Memory) ListFeeds(ctx context.Context) ([]types.Feed, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var feeds []types.Feed
+	for _, doc := range m.feeds {
+		var feed types.Feed
+		if err := fromDoc(doc, &feed); err != nil {
+			continue
+		}
+		feeds = append(feeds, feed)
+	}
+	sort.Slice(feeds, func(i, j int) bool { return feeds[i].ID < feeds[j].ID })
+	return feeds, nil
+}
+
+// SaveFeed creates or replaces a feed
+func (m *Memory) SaveFeed(ctx context.Context, feed *types.Feed) error {
+	doc, err := toDoc(feed)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.feeds[strconv.FormatInt(feed.ID, 10)] = doc
+	return nil
+}
+
+// DeleteFeed deletes single feed
+func (m *Memory) DeleteFeed(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.feeds, strconv.FormatInt(id, 10))
+	return nil
+}
+
+// GetEntry returns single entry of a collection
+func (m *Memory) GetEntry(ctx context.Context, collection, id string) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, ok := m.entries[collection][id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyDoc(doc), nil
+}
+
+// ListEntries returns entries ordered by published_at descending,
+// it mimics Firestore OrderBy("published_at", Desc).StartAfter(cursor) query.
+func (m *Memory) ListEntries(ctx context.Context, q EntryQuery) ([]map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []map[string]interface{}
+	for _, doc := range m.entries[q.Collection] {
+		if q.Cursor > 0 && toInt64(doc["published_at"]) >= q.Cursor {
+			continue
+		}
+		if q.CategoryID > 0 && toInt64(doc["category_id"]) != q.CategoryID {
+			continue
+		}
+		items = append(items, copyDoc(doc))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return toInt64(items[i]["published_at"]) > toInt64(items[j]["published_at"])
+	})
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+	return items, nil
+}
+
+// SaveEntry creates or replaces an entry of a collection
+func (m *Memory) SaveEntry(ctx context.Context, collection, id string, entry *types.Entry) error {
+	doc, err := toDoc(entry)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[collection]; !ok {
+		m.entries[collection] = make(map[string]map[string]interface{})
+	}
+	m.entries[collection][id] = doc
+	return nil
+}
+
+// CountEntries returns number of entries in a collection
+func (m *Memory) CountEntries(ctx context.Context, collection string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.entries[collection]), nil
+}
+
+// DeleteEntry deletes single entry of a collection
+func (m *Memory) DeleteEntry(ctx context.Context, collection, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries[collection], id)
+	return nil
+}
+
+// GetEntryRef returns the location of entry with given Miniflux ID
+func (m *Memory) GetEntryRef(ctx context.Context, minifluxID int64) (*EntryRef, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ref, ok := m.entryIndex[minifluxID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &ref, nil
+}
+
+// SaveEntryRef creates or replaces the location of entry with given Miniflux ID
+func (m *Memory) SaveEntryRef(ctx context.Context, minifluxID int64, ref *EntryRef) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entryIndex[minifluxID] = *ref
+	return nil
+}
+
+// DeleteEntryRef deletes the location of entry with given Miniflux ID
+func (m *Memory) DeleteEntryRef(ctx context.Context, minifluxID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entryIndex, minifluxID)
+	return nil
+}
+
+// GetResponse returns single entry response
+func (m *Memory) GetResponse(ctx context.Context, id string) (*types.Response, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return (&memoryTx{m: m}).GetResponse(id)
+}
+
+// SaveResponse creates or replaces an entry response
+func (m *Memory) SaveResponse(ctx context.Context, r *types.Response) error {
+	doc, err := toDoc(r)
+	if err != nil {
+		return err
+	}
+	delete(doc, "id")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.responses[r.ID] = doc
+	return nil
+}
+
+// CreateResponse creates an entry response, returns ErrAlreadyExists when its ID is taken
+func (m *Memory) CreateResponse(ctx context.Context, r *types.Response) error {
+	doc, err := toDoc(r)
+	if err != nil {
+		return err
+	}
+	delete(doc, "id")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.responses[r.ID]; exists {
+		return ErrAlreadyExists
+	}
+	m.responses[r.ID] = doc
+	return nil
+}
+
+// UpdateResponse sets top level fields of an entry response, other fields are kept
+func (m *Memory) UpdateResponse(ctx context.Context, id string, fields map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.responses[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for name, value := range fields {
+		doc[name] = value
+	}
+	return nil
+}
+
+// ListResponses returns entry responses matching the query
+func (m *Memory) ListResponses(ctx context.Context, q ResponseQuery) ([]types.Response, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	responses := []types.Response{}
+	for id, doc := range m.responses {
+		if q.ThreadID != "" && doc["thread_id"] != q.ThreadID {
+			continue
+		}
+		if q.ParentID != "" && doc["parent_id"] != q.ParentID {
+			continue
+		}
+		if q.EntryID != 0 && toInt64(doc["entry_id"]) != q.EntryID {
+			continue
+		}
+		if q.UserID != "" && doc["user_id"] != q.UserID {
+			continue
+		}
+		if q.Type != "" && doc["type"] != q.Type {
+			continue
+		}
+		var r types.Response
+		if err := fromDoc(doc, &r); err != nil {
+			return nil, err
+		}
+		r.ID = id
+		responses = append(responses, r)
+	}
+	sort.Slice(responses, func(i, j int) bool { return responses[i].ID < responses[j].ID })
+	return responses, nil
+}
+
+// ListComments returns top level comments of an entry, newest first
+func (m *Memory) ListComments(ctx context.Context, q CommentQuery) ([]types.Response, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	comments := []types.Response{}
+	for id, doc := range m.responses {
+		var r types.Response
+		if err := fromDoc(doc, &r); err != nil {
+			return nil, err
+		}
+		if r.EntryID != q.EntryID || r.Type != "COMMENT" || r.ThreadID != "" {
+			continue
+		}
+		if q.CursorID != "" && (r.CreatedAt > q.Cursor || (r.CreatedAt == q.Cursor && id >= q.CursorID)) {
+			continue
+		}
+		if q.CursorID == "" && q.Cursor > 0 && r.CreatedAt >= q.Cursor {
+			continue
+		}
+		r.ID = id
+		comments = append(comments, r)
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].CreatedAt != comments[j].CreatedAt {
+			return comments[i].CreatedAt > comments[j].CreatedAt
+		}
+		return comments[i].ID > comments[j].ID
+	})
+	if q.Limit > 0 && len(comments) > q.Limit {
+		comments = comments[:q.Limit]
+	}
+	return comments, nil
+}
+
+// ListReplies returns comments in a thread, oldest first
+func (m *Memory) ListReplies(ctx context.Context, q ReplyQuery) ([]types.Response, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	replies := []types.Response{}
+	for id, doc := range m.responses {
+		if doc["thread_id"] != q.ThreadID || doc["type"] != "COMMENT" {
+			continue
+		}
+		var r types.Response
+		if err := fromDoc(doc, &r); err != nil {
+			return nil, err
+		}
+		r.ID = id
+		replies = append(replies, r)
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		if replies[i].CreatedAt != replies[j].CreatedAt {
+			return replies[i].CreatedAt < replies[j].CreatedAt
+		}
+		return replies[i].ID < replies[j].ID
+	})
+	if q.Limit > 0 && len(replies) > q.Limit {
+		replies = replies[:q.Limit]
+	}
+	return replies, nil
+}
+
+// DeleteResponses deletes entry responses by their IDs
+func (m *Memory) DeleteResponses(ctx context.Context, ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.responses, id)
+	}
+	return nil
+}
+
+// AddSubscriber subscribes user to a category,
+// in production subscribers are written by the app directly.
+func (m *Memory) AddSubscriber(ctx context.Context, categoryID string, sub *types.Subscriber) error {
+	doc, err := toDoc(sub)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subscribers[categoryID]; !ok {
+		m.subscribers[categoryID] = make(map[string]map[string]interface{})
+	}
+	m.subscribers[categoryID][sub.ID] = doc
+	return nil
+}
+
+// ListSubscribers returns subscribers of a category
+func (m *Memory) ListSubscribers(ctx context.Context, categoryID string) ([]types.Subscriber, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subscribers []types.Subscriber
+	for id, doc := range m.subscribers[categoryID] {
+		var sub types.Subscriber
+		if err := fromDoc(doc, &sub); err != nil {
+			continue
+		}
+		sub.ID = id
+		subscribers = append(subscribers, sub)
+	}
+	sort.Slice(subscribers, func(i, j int) bool { return subscribers[i].ID < subscribers[j].ID })
+	return subscribers, nil
+}
+
+// DeleteSubscriber removes a subscriber from a category
+func (m *Memory) DeleteSubscriber(ctx context.Context, categoryID, subscriberID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.subscribers[categoryID], subscriberID)
+	return nil
+}
+
+// ListTopicUsers returns IDs of users whose devices are subscribed to FCM topic
+func (m *Memory) ListTopicUsers(ctx context.Context, topic string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for id := range m.topicUsers[topic] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// SetTopicUser records the user whose devices are subscribed to FCM topic, or removes it when not subscribed
+func (m *Memory) SetTopicUser(ctx context.Context, topic, userID string, subscribed bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !subscribed {
+		delete(m.topicUsers[topic], userID)
+		return nil
+	}
+	if _, ok := m.topicUsers[topic]; !ok {
+		m.topicUsers[topic] = make(map[string]bool)
+	}
+	m.topicUsers[topic][userID] = true
+	return nil
+}
+
+// SaveUser creates or replaces a user,
+// in production users are written by the app directly.
+func (m *Memory) SaveUser(ctx context.Context, user *types.User) error {
+	doc, err := toDoc(user)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[user.ID] = doc
+	return nil
+}
+
+// GetUser returns single user
+func (m *Memory) GetUser(ctx context.Context, id string) (*types.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var user types.User
+	if err := fromDoc(doc, &user); err != nil {
+		return nil, err
+	}
+	user.ID = id
+	return &user, nil
+}
+
+// SetUserTokens replaces user's FCM tokens
+func (m *Memory) SetUserTokens(ctx context.Context, id string, tokens map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	doc["fcm_tokens"] = copyDoc(tokens)
+	return nil
+}
+
+// ClaimMessage records message id in the processed-message ledger
+func (m *Memory) ClaimMessage(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return claimMessage(ctx, m, id, ttl)
+}
+
+// ReleaseMessage removes message id from the processed-message ledger
+func (m *Memory) ReleaseMessage(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.processed, id)
+	return nil
+}
+
+// IncrementAttempts increments and returns delivery attempts of message id
+func (m *Memory) IncrementAttempts(ctx context.Context, id string, ttl time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.attempts[id]
+	if a.expiresAt.Before(time.Now()) {
+		a.count = 0
+	}
+	a.count++
+	a.expiresAt = time.Now().Add(ttl)
+	m.attempts[id] = a
+	return a.count, nil
+}
+
+// IncrementCounter increments and returns the counter of key within a fixed window
+func (m *Memory) IncrementCounter(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.counters[key]
+	if c.expiresAt.Before(time.Now()) {
+		c = attempts{expiresAt: time.Now().Add(window)}
+	}
+	c.count++
+	m.counters[key] = c
+	return c.count, nil
+}
+
+// SaveDeadLetter parks the failed message
+func (m *Memory) SaveDeadLetter(ctx context.Context, msg *types.DeadLetter) error {
+	doc, err := toDoc(msg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadLetters[msg.ID] = doc
+	return nil
+}
+
+// GetDeadLetter returns single parked message
+func (m *Memory) GetDeadLetter(ctx context.Context, id string) (*types.DeadLetter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, ok := m.deadLetters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var msg types.DeadLetter
+	if err := fromDoc(doc, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ListDeadLetters returns parked messages matching the query
+func (m *Memory) ListDeadLetters(ctx context.Context, q DeadLetterQuery) ([]types.DeadLetter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	messages := []types.DeadLetter{}
+	for _, doc := range m.deadLetters {
+		if q.Path != "" && doc["path"] != q.Path {
+			continue
+		}
+		var msg types.DeadLetter
+		if err := fromDoc(doc, &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].CreatedAt.After(messages[j].CreatedAt) })
+	if q.Limit > 0 && len(messages) > q.Limit {
+		messages = messages[:q.Limit]
+	}
+	return messages, nil
+}
+
+// DeleteDeadLetter deletes single parked message
+func (m *Memory) DeleteDeadLetter(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.deadLetters, id)
+	return nil
+}
+
+// PurgeDeadLetters deletes messages parked before given time
+func (m *Memory) PurgeDeadLetters(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, doc := range m.deadLetters {
+		var msg types.DeadLetter
+		if err := fromDoc(doc, &msg); err != nil {
+			return deleted, err
+		}
+		if msg.CreatedAt.Before(before) {
+			delete(m.deadLetters, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SavePendingNotification holds notification until its DueAt
+func (m *Memory) SavePendingNotification(ctx context.Context, n *types.PendingNotification) error {
+	doc, err := toDoc(n)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending[n.ID] = doc
+	return nil
+}
+
+// ListDuePendingNotifications returns notifications due at or before given time, oldest first
+func (m *Memory) ListDuePendingNotifications(ctx context.Context, before time.Time, limit int) ([]types.PendingNotification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	notifications := []types.PendingNotification{}
+	for _, doc := range m.pending {
+		var n types.PendingNotification
+		if err := fromDoc(doc, &n); err != nil {
+			return nil, err
+		}
+		if !n.DueAt.After(before) {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].DueAt.Before(notifications[j].DueAt) })
+	if limit > 0 && len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+// DeletePendingNotification deletes single pending notification
+func (m *Memory) DeletePendingNotification(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pending, id)
+	return nil
+}
+
+// AddNotification adds notification to user's inbox
+func (m *Memory) AddNotification(ctx context.Context, userID string, n *types.Notification) error {
+	doc, err := toDoc(n)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inbox, ok := m.notifications[userID]
+	if !ok {
+		inbox = make(map[string]map[string]interface{})
+		m.notifications[userID] = inbox
+	}
+	if _, exists := inbox[n.ID]; !exists {
+		inbox[n.ID] = doc
+	}
+	return nil
+}
+
+// ListNotifications returns user's inbox notifications, newest first
+func (m *Memory) ListNotifications(ctx context.Context, q NotificationQuery) ([]types.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := []types.Notification{}
+	for _, doc := range m.notifications[q.UserID] {
+		var n types.Notification
+		if err := fromDoc(doc, &n); err != nil {
+			return nil, err
+		}
+		if q.UnreadOnly && n.Read {
+			continue
+		}
+		if q.Cursor > 0 {
+			ms := n.CreatedAt.UnixNano() / int64(time.Millisecond)
+			if ms > q.Cursor || (ms == q.Cursor && (q.CursorID == "" || n.ID >= q.CursorID)) {
+				continue
+			}
+		}
+		items = append(items, n)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i].CreatedAt.UnixNano()/int64(time.Millisecond), items[j].CreatedAt.UnixNano()/int64(time.Millisecond)
+		if a != b {
+			return a > b
+		}
+		return items[i].ID > items[j].ID
+	})
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+	return items, nil
+}
+
+// CountUnreadNotifications returns number of unread notifications in user's inbox
+func (m *Memory) CountUnreadNotifications(ctx context.Context, userID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, doc := range m.notifications[userID] {
+		if read, _ := doc["read"].(bool); !read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead marks notifications as read, empty ids means all user's notifications
+func (m *Memory) MarkNotificationsRead(ctx context.Context, userID string, ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inbox := m.notifications[userID]
+	if len(ids) == 0 {
+		for _, doc := range inbox {
+			doc["read"] = true
+		}
+		return nil
+	}
+	for _, id := range ids {
+		if doc, ok := inbox[id]; ok {
+			doc["read"] = true
+		}
+	}
+	return nil
+}
+
+// DeleteNotifications deletes notifications from user's inbox, empty ids means all user's notifications
+func (m *Memory) DeleteNotifications(ctx context.Context, userID string, ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(ids) == 0 {
+		delete(m.notifications, userID)
+		return nil
+	}
+	for _, id := range ids {
+		delete(m.notifications[userID], id)
+	}
+	return nil
+}
+
+// RecordDelivery saves delivery record and adds it to the daily stats
+func (m *Memory) RecordDelivery(ctx context.Context, day string, d *types.Delivery) error {
+	doc, err := toDoc(d)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries[d.ID] = doc
+	stats, ok := m.deliveryStats[statsID(day, d)]
+	if !ok {
+		stats = &types.DeliveryStats{Day: day, DataType: d.DataType, Category: d.Category}
+		m.deliveryStats[statsID(day, d)] = stats
+	}
+	stats.Notifications++
+	stats.Tokens += d.Tokens
+	stats.Sent += d.Sent
+	stats.Pruned += d.Pruned
+	stats.Failed += d.Failed
+	return nil
+}
+
+// ListDeliveries returns delivery records, newest first
+func (m *Memory) ListDeliveries(ctx context.Context, q DeliveryQuery) ([]types.Delivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := []types.Delivery{}
+	for _, doc := range m.deliveries {
+		var d types.Delivery
+		if err := fromDoc(doc, &d); err != nil {
+			return nil, err
+		}
+		if (q.UserID != "" && d.UserID != q.UserID) ||
+			(q.EntryID != "" && d.EntryID != q.EntryID) ||
+			(q.ResponseID != "" && d.ResponseID != q.ResponseID) {
+			continue
+		}
+		items = append(items, d)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.After(items[j].CreatedAt) })
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+	return items, nil
+}
+
+// ListDeliveryStats returns daily delivery stats from day to day
+func (m *Memory) ListDeliveryStats(ctx context.Context, from, to string) ([]types.DeliveryStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := []types.DeliveryStats{}
+	for _, stats := range m.deliveryStats {
+		if stats.Day >= from && stats.Day <= to {
+			items = append(items, *stats)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.DataType != b.DataType {
+			return a.DataType < b.DataType
+		}
+		return a.Category < b.Category
+	})
+	return items, nil
+}
+
+// RunTransaction runs fn while holding the store lock,
+// writes are buffered and only applied when fn returns no error.
+func (m *Memory) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &memoryTx{m: m}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	for _, w := range tx.writes {
+		w()
+	}
+	return nil
+}
+
+// memoryTx implements Tx, caller must hold the store lock
+type memoryTx struct {
+	m      *Memory
+	writes []func()
+}
+
+func (t *memoryTx) GetResponse(id string) (*types.Response, error) {
+	doc, ok := t.m.responses[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var r types.Response
+	if err := fromDoc(doc, &r); err != nil {
+		return nil, err
+	}
+	r.ID = id
+	return &r, nil
+}
+
+func (t *memoryTx) IncrementEntry(collection, id string, counters map[string]int) error {
+	doc, ok := t.m.entries[collection][id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.writes = append(t.writes, func() { increment(doc, counters) })
+	return nil
+}
+
+func (t *memoryTx) IncrementResponse(id string, counters map[string]int) error {
+	doc, ok := t.m.responses[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.writes = append(t.writes, func() { increment(doc, counters) })
+	return nil
+}
+
+func (t *memoryTx) ClaimMessage(id string, ttl time.Duration) (bool, error) {
+	if expiresAt, ok := t.m.processed[id]; ok && expiresAt.After(time.Now()) {
+		return false, nil
+	}
+	t.writes = append(t.writes, func() { t.m.processed[id] = time.Now().Add(ttl) })
+	return true, nil
+}
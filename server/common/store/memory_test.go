@@ -0,0 +1,352 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"server/common/types"
+)
+
+func TestMemoryListEntries(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	for i, cat := range []int64{1, 2, 1, 2, 1} {
+		entry := &types.Entry{ID: int64(i + 1), CategoryID: cat, PublishedAt: int64(1000 * (i + 1))}
+		if err := m.SaveEntry(ctx, "entries", strconv.Itoa(i+1), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, _ := m.ListEntries(ctx, EntryQuery{Collection: "entries", Limit: 2})
+	if len(items) != 2 {
+		t.Fatal("Wrong number of entries")
+	}
+	if toInt64(items[0]["published_at"]) != 5000 || toInt64(items[1]["published_at"]) != 4000 {
+		t.Error("Entries not ordered by published_at desc")
+	}
+
+	items, _ = m.ListEntries(ctx, EntryQuery{Collection: "entries", Cursor: 4000, Limit: 10})
+	if len(items) != 3 || toInt64(items[0]["published_at"]) != 3000 {
+		t.Error("Wrong cursor result")
+	}
+
+	items, _ = m.ListEntries(ctx, EntryQuery{Collection: "entries", CategoryID: 2, Limit: 10})
+	if len(items) != 2 {
+		t.Error("Wrong category filter result")
+	}
+	for _, item := range items {
+		if toInt64(item["category_id"]) != 2 {
+			t.Error("Wrong category_id value")
+		}
+	}
+}
+
+func TestMemoryTransaction(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.SaveEntry(ctx, "entries", "1", &types.Entry{ID: 1})
+	m.SaveResponse(ctx, &types.Response{ID: "r1", Type: "COMMENT"})
+
+	err := m.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		if _, err := tx.GetResponse("r1"); err != nil {
+			return err
+		}
+		if err := tx.IncrementEntry("entries", "1", map[string]int{"comment_count": 1}); err != nil {
+			return err
+		}
+		return tx.IncrementResponse("r1", map[string]int{"reply_count": 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _ := m.GetEntry(ctx, "entries", "1")
+	if toInt64(entry["comment_count"]) != 1 {
+		t.Error("Wrong comment_count value")
+	}
+	if toInt64(m.responses["r1"]["reply_count"]) != 2 {
+		t.Error("Wrong reply_count value")
+	}
+
+	// failed transaction must not apply any writes
+	failed := errors.New("failed")
+	err = m.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		tx.IncrementEntry("entries", "1", map[string]int{"comment_count": 1})
+		return failed
+	})
+	if err != failed {
+		t.Error("Wrong transaction error")
+	}
+	entry, _ = m.GetEntry(ctx, "entries", "1")
+	if toInt64(entry["comment_count"]) != 1 {
+		t.Error("Failed transaction was applied")
+	}
+
+	err = m.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		return tx.IncrementEntry("entries", "404", map[string]int{"comment_count": 1})
+	})
+	if err != ErrNotFound {
+		t.Error("Increment on missing entry should return ErrNotFound")
+	}
+}
+
+func TestMemoryListResponses(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.SaveResponse(ctx, &types.Response{ID: "a"})
+	m.SaveResponse(ctx, &types.Response{ID: "b", ParentID: "a", ThreadID: "a"})
+	m.SaveResponse(ctx, &types.Response{ID: "c", ParentID: "b", ThreadID: "a"})
+
+	replies, _ := m.ListResponses(ctx, ResponseQuery{ThreadID: "a"})
+	if len(replies) != 2 {
+		t.Error("Wrong thread_id filter result")
+	}
+	replies, _ = m.ListResponses(ctx, ResponseQuery{ParentID: "b"})
+	if len(replies) != 1 || replies[0].ID != "c" {
+		t.Error("Wrong parent_id filter result")
+	}
+
+	m.DeleteResponses(ctx, []string{"b", "c"})
+	replies, _ = m.ListResponses(ctx, ResponseQuery{ThreadID: "a"})
+	if len(replies) != 0 {
+		t.Error("Responses not deleted")
+	}
+}
+
+func TestMemoryCreateUpdateResponse(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if err := m.CreateResponse(ctx, &types.Response{ID: "a", Reaction: "HAPPY"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateResponse(ctx, &types.Response{ID: "a", Reaction: "SAD"}); err != ErrAlreadyExists {
+		t.Errorf("CreateResponse() = %v, want ErrAlreadyExists", err)
+	}
+
+	m.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		return tx.IncrementResponse("a", map[string]int{"reply_count": 1})
+	})
+	if err := m.UpdateResponse(ctx, "a", map[string]interface{}{"reaction": "SAD"}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := m.GetResponse(ctx, "a"); r.Reaction != "SAD" || r.ReplyCount != 1 {
+		t.Errorf("Unexpected updated response %+v", r)
+	}
+	if err := m.UpdateResponse(ctx, "b", map[string]interface{}{"reaction": "SAD"}); err != ErrNotFound {
+		t.Errorf("UpdateResponse() = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryLedger(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	calls := 0
+	fn := func() error { calls++; return nil }
+	if err := Once(ctx, m, "msg-1", time.Hour, fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := Once(ctx, m, "msg-1", time.Hour, fn); err != ErrDuplicate {
+		t.Error("Expected ErrDuplicate, got", err)
+	}
+	if calls != 1 {
+		t.Error("fn should be called once, called", calls)
+	}
+
+	// failed message is released so it can be redelivered
+	failed := errors.New("failed")
+	if err := Once(ctx, m, "msg-2", time.Hour, func() error { return failed }); err != failed {
+		t.Error("Expected fn error, got", err)
+	}
+	if claimed, _ := m.ClaimMessage(ctx, "msg-2", time.Hour); !claimed {
+		t.Error("Failed message should be released")
+	}
+
+	// expired record can be claimed again
+	m.ClaimMessage(ctx, "msg-3", -time.Second)
+	if claimed, _ := m.ClaimMessage(ctx, "msg-3", time.Hour); !claimed {
+		t.Error("Expired message should be claimed again")
+	}
+
+	// claim is rolled back with the transaction
+	m.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		tx.ClaimMessage("msg-4", time.Hour)
+		return failed
+	})
+	if claimed, _ := m.ClaimMessage(ctx, "msg-4", time.Hour); !claimed {
+		t.Error("Claim should be rolled back")
+	}
+}
+
+func TestMemoryTopicUsers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	m.SetTopicUser(ctx, "category_13", "b", true)
+	m.SetTopicUser(ctx, "category_13", "a", true)
+	m.SetTopicUser(ctx, "category_13", "a", true)
+	m.SetTopicUser(ctx, "category_5", "c", true)
+	if users, _ := m.ListTopicUsers(ctx, "category_13"); len(users) != 2 || users[0] != "a" || users[1] != "b" {
+		t.Errorf("ListTopicUsers() = %v, want [a b]", users)
+	}
+
+	m.SetTopicUser(ctx, "category_13", "a", false)
+	if users, _ := m.ListTopicUsers(ctx, "category_13"); len(users) != 1 || users[0] != "b" {
+		t.Errorf("ListTopicUsers() = %v, want [b]", users)
+	}
+}
+
+func TestMemoryIncrementCounter(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	for i := 1; i <= 3; i++ {
+		if count, _ := m.IncrementCounter(ctx, "a", time.Hour); count != i {
+			t.Errorf("IncrementCounter() = %d, want %d", count, i)
+		}
+	}
+	// counters are separate from delivery attempts
+	if attempts, _ := m.IncrementAttempts(ctx, "a", time.Hour); attempts != 1 {
+		t.Errorf("IncrementAttempts() = %d, want 1", attempts)
+	}
+
+	// ended window restarts the counter
+	m.IncrementCounter(ctx, "b", -time.Second)
+	if count, _ := m.IncrementCounter(ctx, "b", time.Hour); count != 1 {
+		t.Errorf("IncrementCounter() = %d, want 1", count)
+	}
+}
+
+func TestMemoryDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	now := time.Now()
+	m.SaveDeadLetter(ctx, &types.DeadLetter{ID: "1", Path: "/pubsub/sync-data", CreatedAt: now.Add(-2 * time.Hour)})
+	m.SaveDeadLetter(ctx, &types.DeadLetter{ID: "2", Path: "/pubsub/firestore-events", CreatedAt: now.Add(-time.Hour)})
+	m.SaveDeadLetter(ctx, &types.DeadLetter{ID: "3", Path: "/pubsub/sync-data", Data: []byte(`{"a":1}`), CreatedAt: now})
+
+	items, _ := m.ListDeadLetters(ctx, DeadLetterQuery{Path: "/pubsub/sync-data"})
+	if len(items) != 2 || items[0].ID != "3" || string(items[0].Data) != `{"a":1}` {
+		t.Error("Wrong dead letters:", items)
+	}
+
+	deleted, _ := m.PurgeDeadLetters(ctx, now.Add(-30*time.Minute))
+	if deleted != 2 {
+		t.Error("Expected 2 purged, got", deleted)
+	}
+	if _, err := m.GetDeadLetter(ctx, "3"); err != nil {
+		t.Error("Newer dead letter should be kept:", err)
+	}
+}
+
+func TestMemoryNotifications(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	start := time.Date(2020, 1, 10, 8, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		n := &types.Notification{ID: strconv.Itoa(i), Title: "t", CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := m.AddNotification(ctx, "u1", n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// existing notification is kept
+	m.AddNotification(ctx, "u1", &types.Notification{ID: "1", Title: "replaced", CreatedAt: start})
+
+	items, _ := m.ListNotifications(ctx, NotificationQuery{UserID: "u1", Limit: 2})
+	if len(items) != 2 || items[0].ID != "3" || items[1].ID != "2" {
+		t.Fatalf("Notifications not ordered by created_at desc: %+v", items)
+	}
+	cursor := items[1].CreatedAt.UnixNano() / int64(time.Millisecond)
+	items, _ = m.ListNotifications(ctx, NotificationQuery{UserID: "u1", Cursor: cursor, CursorID: items[1].ID, Limit: 2})
+	if len(items) != 1 || items[0].ID != "1" || items[0].Title != "t" {
+		t.Errorf("Wrong cursor result: %+v", items)
+	}
+
+	// notifications created in the same millisecond are paged by ID
+	same := start.Add(time.Hour)
+	for _, id := range []string{"a", "b", "c"} {
+		m.AddNotification(ctx, "u2", &types.Notification{ID: id, CreatedAt: same})
+	}
+	items, _ = m.ListNotifications(ctx, NotificationQuery{UserID: "u2", Limit: 2})
+	cursor = items[1].CreatedAt.UnixNano() / int64(time.Millisecond)
+	next, _ := m.ListNotifications(ctx, NotificationQuery{UserID: "u2", Cursor: cursor, CursorID: items[1].ID, Limit: 2})
+	if len(items) != 2 || items[0].ID != "c" || items[1].ID != "b" || len(next) != 1 || next[0].ID != "a" {
+		t.Errorf("Wrong same millisecond pages: %+v, %+v", items, next)
+	}
+
+	m.MarkNotificationsRead(ctx, "u1", []string{"2", "unknown"})
+	if count, _ := m.CountUnreadNotifications(ctx, "u1"); count != 2 {
+		t.Errorf("CountUnreadNotifications() = %d, want 2", count)
+	}
+	items, _ = m.ListNotifications(ctx, NotificationQuery{UserID: "u1", UnreadOnly: true})
+	if len(items) != 2 {
+		t.Errorf("Wrong unread only result: %+v", items)
+	}
+
+	m.DeleteNotifications(ctx, "u1", []string{"3"})
+	m.MarkNotificationsRead(ctx, "u1", nil)
+	if count, _ := m.CountUnreadNotifications(ctx, "u1"); count != 0 {
+		t.Errorf("CountUnreadNotifications() = %d, want 0", count)
+	}
+	m.DeleteNotifications(ctx, "u1", nil)
+	if items, _ := m.ListNotifications(ctx, NotificationQuery{UserID: "u1"}); len(items) != 0 {
+		t.Error("Inbox should be cleared")
+	}
+}
+
+func TestMemoryListComments(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	for i, r := range []*types.Response{
+		{ID: "a", Type: "COMMENT", EntryID: 1, CreatedAt: 1000},
+		{ID: "b", Type: "COMMENT", EntryID: 1, CreatedAt: 2000},
+		{ID: "c", Type: "COMMENT", EntryID: 1, ParentID: "a", ThreadID: "a", CreatedAt: 3000},
+		{ID: "d", Type: "REACTION", EntryID: 1, Reaction: "HAPPY", CreatedAt: 4000},
+		{ID: "e", Type: "COMMENT", EntryID: 2, CreatedAt: 5000},
+		{ID: "f", Type: "COMMENT", EntryID: 1, ParentID: "a", ThreadID: "a", CreatedAt: 2500},
+		// written before created_at was added
+		{ID: "g", Type: "COMMENT", EntryID: 1},
+		{ID: "h", Type: "COMMENT", EntryID: 1},
+	} {
+		if err := m.SaveResponse(ctx, r); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+
+	comments, _ := m.ListComments(ctx, CommentQuery{EntryID: 1, Limit: 1})
+	if len(comments) != 1 || comments[0].ID != "b" {
+		t.Fatalf("Wrong first page: %+v", comments)
+	}
+	comments, _ = m.ListComments(ctx, CommentQuery{EntryID: 1, Cursor: comments[0].CreatedAt, Limit: 1})
+	if len(comments) != 1 || comments[0].ID != "a" {
+		t.Errorf("Wrong cursor result: %+v", comments)
+	}
+
+	// comments without created_at are paged by ID
+	var ids []string
+	cursor := CommentQuery{EntryID: 1, Limit: 1}
+	for i := 0; i < 5; i++ {
+		comments, _ = m.ListComments(ctx, cursor)
+		if len(comments) == 0 {
+			break
+		}
+		ids = append(ids, comments[0].ID)
+		cursor.Cursor, cursor.CursorID = comments[0].CreatedAt, comments[0].ID
+	}
+	if strings.Join(ids, ",") != "b,a,h,g" {
+		t.Errorf("Wrong pages: %v", ids)
+	}
+
+	replies, _ := m.ListReplies(ctx, ReplyQuery{ThreadID: "a", Limit: 1})
+	if len(replies) != 1 || replies[0].ID != "f" {
+		t.Errorf("Wrong first reply: %+v", replies)
+	}
+}
@@ -0,0 +1,1156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/common/types"
+)
+
+// SQL is a Store backed by SQL database, it supports SQLite and Postgres.
+// Entries and responses are stored as JSON document next to the columns used for querying,
+// aggregated counters (comment_count, reply_count, etc.) are stored in separate tables.
+type SQL struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQL opens the database and runs the schema migrations,
+// driver is the database/sql driver name ("sqlite3" or "postgres").
+func NewSQL(ctx context.Context, driver, dsn string) (*SQL, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite3" {
+		// SQLite only allows single writer
+		db.SetMaxOpenConns(1)
+	}
+	s := &SQL{db: db, driver: driver}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+// querier is the common interface of *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rebind replaces `?` placeholders with `$n` for Postgres
+func (s *SQL) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *SQL) exec(ctx context.Context, q querier, query string, args ...interface{}) error {
+	_, err := q.ExecContext(ctx, s.rebind(query), args...)
+	return err
+}
+
+// migrate applies schema migrations that haven't been applied yet
+func (s *SQL) migrate(ctx context.Context) error {
+	if err := s.exec(ctx, s.db, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i, stmts := range migrations {
+		version := i + 1
+		if version <= current {
+			continue
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range stmts {
+			if err := s.exec(ctx, tx, stmt); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := s.exec(ctx, tx, `INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCategory returns single category
+func (s *SQL) GetCategory(ctx context.Context, id string) (*types.Category, error) {
+	var category types.Category
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT title FROM categories WHERE id = ?`), id).Scan(&category.Title)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	category.ID, _ = strconv.ParseInt(id, 10, 64)
+	return &category, nil
+}
+
+// ListCategories returns all categories
+func (s *SQL) ListCategories(ctx context.Context) ([]types.Category, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, title FROM categories ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []types.Category
+	for rows.Next() {
+		var id string
+		var category types.Category
+		if err := rows.Scan(&id, &category.Title); err != nil {
+			return nil, err
+		}
+		category.ID, _ = strconv.ParseInt(id, 10, 64)
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// SaveCategories creates or replaces categories in a transaction
+func (s *SQL) SaveCategories(ctx context.Context, categories []types.Category) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, cat := range categories {
+		err := s.exec(ctx, tx, `INSERT INTO categories (id, title) VALUES (?, ?)
+			ON CONFLICT (id) DO UPDATE SET title = excluded.title`,
+			strconv.FormatInt(cat.ID, 10), cat.Title)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteCategory deletes single category
+func (s *SQL) DeleteCategory(ctx context.Context, id int64) error {
+	return s.exec(ctx, s.db, `DELETE FROM categories WHERE id = ?`, strconv.FormatInt(id, 10))
+}
+
+// ListFeeds returns all feeds ordered by ID
+func (s *SQL) ListFeeds(ctx context.Context) ([]types.Feed, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM feeds ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []types.Feed
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var feed types.Feed
+		if err := json.Unmarshal([]byte(data), &feed); err != nil {
+			continue
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+// SaveFeed creates or replaces a feed
+func (s *SQL) SaveFeed(ctx context.Context, feed *types.Feed) error {
+	data, err := json.Marshal(feed)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO feeds (id, category_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET category_id = excluded.category_id, data = excluded.data`,
+		feed.ID, feed.Category, string(data))
+}
+
+// DeleteFeed deletes single feed
+func (s *SQL) DeleteFeed(ctx context.Context, id int64) error {
+	return s.exec(ctx, s.db, `DELETE FROM feeds WHERE id = ?`, id)
+}
+
+// counters returns aggregated counters of an entry
+func (s *SQL) counters(ctx context.Context, q querier, collection, id string) (map[string]int64, error) {
+	rows, err := q.QueryContext(ctx, s.rebind(`SELECT name, value FROM entry_counters WHERE collection = ? AND entry_id = ?`), collection, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counters := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		counters[name] = value
+	}
+	return counters, rows.Err()
+}
+
+// entryDoc converts stored entry JSON and its counters into a document map
+func entryDoc(data string, counters map[string]int64) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, err
+	}
+	for name, value := range counters {
+		doc[name] = value
+	}
+	return doc, nil
+}
+
+// GetEntry returns single entry of a collection including its counters
+func (s *SQL) GetEntry(ctx context.Context, collection, id string) (map[string]interface{}, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT data FROM entries WHERE collection = ? AND id = ?`), collection, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	counters, err := s.counters(ctx, s.db, collection, id)
+	if err != nil {
+		return nil, err
+	}
+	return entryDoc(data, counters)
+}
+
+// ListEntries returns entries ordered by published_at descending
+func (s *SQL) ListEntries(ctx context.Context, q EntryQuery) ([]map[string]interface{}, error) {
+	query := `SELECT id, data FROM entries WHERE collection = ?`
+	args := []interface{}{q.Collection}
+	if q.Cursor > 0 {
+		query += ` AND published_at < ?`
+		args = append(args, q.Cursor)
+	}
+	if q.CategoryID > 0 {
+		query += ` AND category_id = ?`
+		args = append(args, q.CategoryID)
+	}
+	query += ` ORDER BY published_at DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	type row struct{ id, data string }
+	var found []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = append(found, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for _, r := range found {
+		counters, err := s.counters(ctx, s.db, q.Collection, r.id)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := entryDoc(r.data, counters)
+		if err != nil {
+			continue
+		}
+		items = append(items, doc)
+	}
+	return items, nil
+}
+
+// SaveEntry creates or replaces an entry of a collection, counters are preserved
+func (s *SQL) SaveEntry(ctx context.Context, collection, id string, entry *types.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO entries (collection, id, entry_id, feed_id, category_id, published_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (collection, id) DO UPDATE SET
+			entry_id = excluded.entry_id,
+			feed_id = excluded.feed_id,
+			category_id = excluded.category_id,
+			published_at = excluded.published_at,
+			data = excluded.data`,
+		collection, id, entry.ID, entry.FeedID, entry.CategoryID, entry.PublishedAt, string(data))
+}
+
+// CountEntries returns number of entries in a collection
+func (s *SQL) CountEntries(ctx context.Context, collection string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM entries WHERE collection = ?`), collection).Scan(&count)
+	return count, err
+}
+
+// DeleteEntry deletes single entry of a collection and its counters
+func (s *SQL) DeleteEntry(ctx context.Context, collection, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.exec(ctx, tx, `DELETE FROM entries WHERE collection = ? AND id = ?`, collection, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := s.exec(ctx, tx, `DELETE FROM entry_counters WHERE collection = ? AND entry_id = ?`, collection, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// responseSelect selects entry responses with their reply_count counter
+const responseSelect = `SELECT r.id, r.data, COALESCE(c.value, 0) FROM entry_responses r
+	LEFT JOIN response_counters c ON c.response_id = r.id AND c.name = 'reply_count'`
+
+// GetResponse returns single entry response
+func (s *SQL) GetResponse(ctx context.Context, id string) (*types.Response, error) {
+	responses, err := s.queryResponses(ctx, responseSelect+` WHERE r.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, ErrNotFound
+	}
+	return &responses[0], nil
+}
+
+// queryResponses returns entry responses selected by responseSelect query
+func (s *SQL) queryResponses(ctx context.Context, query string, args ...interface{}) ([]types.Response, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := []types.Response{}
+	for rows.Next() {
+		var id, data string
+		var replyCount int64
+		if err := rows.Scan(&id, &data, &replyCount); err != nil {
+			return nil, err
+		}
+		var r types.Response
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, err
+		}
+		r.ID = id
+		r.ReplyCount = replyCount
+		responses = append(responses, r)
+	}
+	return responses, rows.Err()
+}
+
+// SaveResponse creates or replaces an entry response
+func (s *SQL) SaveResponse(ctx context.Context, r *types.Response) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO entry_responses (id, entry_id, user_id, type, parent_id, thread_id, created_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			entry_id = excluded.entry_id,
+			user_id = excluded.user_id,
+			type = excluded.type,
+			parent_id = excluded.parent_id,
+			thread_id = excluded.thread_id,
+			created_at = excluded.created_at,
+			data = excluded.data`,
+		r.ID, r.EntryID, r.UserID, r.Type, r.ParentID, r.ThreadID, r.CreatedAt, string(data))
+}
+
+// CreateResponse creates an entry response, returns ErrAlreadyExists when its ID is taken
+func (s *SQL) CreateResponse(ctx context.Context, r *types.Response) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// single statement so concurrent creates can't both win
+	res, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO entry_responses (id, entry_id, user_id, type, parent_id, thread_id, created_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`),
+		r.ID, r.EntryID, r.UserID, r.Type, r.ParentID, r.ThreadID, r.CreatedAt, string(data))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+// UpdateResponse sets top level fields of an entry response, counters are kept in response_counters.
+// Fields stored in their own columns (eg. thread_id) can't be updated.
+func (s *SQL) UpdateResponse(ctx context.Context, id string, fields map[string]interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var data string
+	err = tx.QueryRowContext(ctx, s.rebind(`SELECT data FROM entry_responses WHERE id = ?`), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for name, value := range fields {
+		doc[name] = value
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := s.exec(ctx, tx, `UPDATE entry_responses SET data = ? WHERE id = ?`, string(b), id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetEntryRef returns the location of entry with given Miniflux ID
+func (s *SQL) GetEntryRef(ctx context.Context, minifluxID int64) (*EntryRef, error) {
+	var ref EntryRef
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT collection, id FROM entry_index WHERE miniflux_id = ?`), minifluxID).
+		Scan(&ref.Collection, &ref.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// SaveEntryRef creates or replaces the location of entry with given Miniflux ID
+func (s *SQL) SaveEntryRef(ctx context.Context, minifluxID int64, ref *EntryRef) error {
+	return s.exec(ctx, s.db, `INSERT INTO entry_index (miniflux_id, collection, id) VALUES (?, ?, ?)
+		ON CONFLICT (miniflux_id) DO UPDATE SET collection = excluded.collection, id = excluded.id`,
+		minifluxID, ref.Collection, ref.ID)
+}
+
+// DeleteEntryRef deletes the location of entry with given Miniflux ID
+func (s *SQL) DeleteEntryRef(ctx context.Context, minifluxID int64) error {
+	return s.exec(ctx, s.db, `DELETE FROM entry_index WHERE miniflux_id = ?`, minifluxID)
+}
+
+// ListResponses returns entry responses matching the query
+func (s *SQL) ListResponses(ctx context.Context, q ResponseQuery) ([]types.Response, error) {
+	query := responseSelect + ` WHERE 1 = 1`
+	var args []interface{}
+	if q.ThreadID != "" {
+		query += ` AND r.thread_id = ?`
+		args = append(args, q.ThreadID)
+	}
+	if q.ParentID != "" {
+		query += ` AND r.parent_id = ?`
+		args = append(args, q.ParentID)
+	}
+	if q.EntryID != 0 {
+		query += ` AND r.entry_id = ?`
+		args = append(args, q.EntryID)
+	}
+	if q.UserID != "" {
+		query += ` AND r.user_id = ?`
+		args = append(args, q.UserID)
+	}
+	if q.Type != "" {
+		query += ` AND r.type = ?`
+		args = append(args, q.Type)
+	}
+	query += ` ORDER BY r.id`
+	return s.queryResponses(ctx, query, args...)
+}
+
+// ListComments returns top level comments of an entry, newest first.
+// Comments with the same created_at (eg. 0 of the ones written before it was added) are ordered by ID.
+func (s *SQL) ListComments(ctx context.Context, q CommentQuery) ([]types.Response, error) {
+	query := responseSelect + ` WHERE r.entry_id = ? AND r.type = 'COMMENT' AND r.thread_id = ''`
+	args := []interface{}{q.EntryID}
+	if q.CursorID != "" {
+		query += ` AND (r.created_at < ? OR (r.created_at = ? AND r.id < ?))`
+		args = append(args, q.Cursor, q.Cursor, q.CursorID)
+	} else if q.Cursor > 0 {
+		query += ` AND r.created_at < ?`
+		args = append(args, q.Cursor)
+	}
+	query += ` ORDER BY r.created_at DESC, r.id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	return s.queryResponses(ctx, query, args...)
+}
+
+// ListReplies returns comments in a thread, oldest first
+func (s *SQL) ListReplies(ctx context.Context, q ReplyQuery) ([]types.Response, error) {
+	query := responseSelect + ` WHERE r.thread_id = ? AND r.type = 'COMMENT' ORDER BY r.created_at, r.id`
+	args := []interface{}{q.ThreadID}
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	return s.queryResponses(ctx, query, args...)
+}
+
+// DeleteResponses deletes entry responses and their counters
+func (s *SQL) DeleteResponses(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.exec(ctx, tx, `DELETE FROM entry_responses WHERE id = ?`, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := s.exec(ctx, tx, `DELETE FROM response_counters WHERE response_id = ?`, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddSubscriber subscribes user to a category
+func (s *SQL) AddSubscriber(ctx context.Context, categoryID string, sub *types.Subscriber) error {
+	return s.exec(ctx, s.db, `INSERT INTO subscribers (category_id, id, user_id) VALUES (?, ?, ?)
+		ON CONFLICT (category_id, id) DO UPDATE SET user_id = excluded.user_id`,
+		categoryID, sub.ID, sub.UserID)
+}
+
+// ListSubscribers returns subscribers of a category
+func (s *SQL) ListSubscribers(ctx context.Context, categoryID string) ([]types.Subscriber, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, user_id FROM subscribers WHERE category_id = ? ORDER BY id`), categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []types.Subscriber
+	for rows.Next() {
+		var sub types.Subscriber
+		if err := rows.Scan(&sub.ID, &sub.UserID); err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers, rows.Err()
+}
+
+// DeleteSubscriber removes a subscriber from a category
+func (s *SQL) DeleteSubscriber(ctx context.Context, categoryID, subscriberID string) error {
+	return s.exec(ctx, s.db, `DELETE FROM subscribers WHERE category_id = ? AND id = ?`, categoryID, subscriberID)
+}
+
+// ListTopicUsers returns IDs of users whose devices are subscribed to FCM topic
+func (s *SQL) ListTopicUsers(ctx context.Context, topic string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT user_id FROM topic_users WHERE topic = ? ORDER BY user_id`), topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetTopicUser records the user whose devices are subscribed to FCM topic, or removes it when not subscribed
+func (s *SQL) SetTopicUser(ctx context.Context, topic, userID string, subscribed bool) error {
+	if !subscribed {
+		return s.exec(ctx, s.db, `DELETE FROM topic_users WHERE topic = ? AND user_id = ?`, topic, userID)
+	}
+	return s.exec(ctx, s.db, `INSERT INTO topic_users (topic, user_id) VALUES (?, ?) ON CONFLICT (topic, user_id) DO NOTHING`, topic, userID)
+}
+
+// SaveUser creates or replaces a user
+func (s *SQL) SaveUser(ctx context.Context, user *types.User) error {
+	tokens, err := json.Marshal(user.FCMTokens)
+	if err != nil {
+		return err
+	}
+	preferences, err := json.Marshal(user.Preferences)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO users (id, fcm_tokens, preferences) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET fcm_tokens = excluded.fcm_tokens, preferences = excluded.preferences`,
+		user.ID, string(tokens), string(preferences))
+}
+
+// GetUser returns single user
+func (s *SQL) GetUser(ctx context.Context, id string) (*types.User, error) {
+	var tokens, preferences string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT fcm_tokens, preferences FROM users WHERE id = ?`), id).Scan(&tokens, &preferences)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user := types.User{ID: id}
+	if err := json.Unmarshal([]byte(tokens), &user.FCMTokens); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(preferences), &user.Preferences); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserTokens replaces user's FCM tokens
+func (s *SQL) SetUserTokens(ctx context.Context, id string, tokens map[string]interface{}) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET fcm_tokens = ? WHERE id = ?`), string(data), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClaimMessage records message id in the processed-message ledger
+func (s *SQL) ClaimMessage(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return claimMessage(ctx, s, id, ttl)
+}
+
+// ReleaseMessage removes message id from the processed-message ledger
+func (s *SQL) ReleaseMessage(ctx context.Context, id string) error {
+	return s.exec(ctx, s.db, `DELETE FROM processed_messages WHERE id = ?`, id)
+}
+
+// IncrementAttempts increments and returns delivery attempts of message id
+func (s *SQL) IncrementAttempts(ctx context.Context, id string, ttl time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	err = s.exec(ctx, tx, `INSERT INTO message_attempts (id, attempts, expires_at) VALUES (?, 1, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			attempts = CASE WHEN message_attempts.expires_at < ? THEN 1 ELSE message_attempts.attempts + 1 END,
+			expires_at = excluded.expires_at`,
+		id, now.Add(ttl).Unix(), now.Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var attempts int
+	if err := tx.QueryRowContext(ctx, s.rebind(`SELECT attempts FROM message_attempts WHERE id = ?`), id).Scan(&attempts); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return attempts, tx.Commit()
+}
+
+// IncrementCounter increments and returns the counter of key within a fixed window
+func (s *SQL) IncrementCounter(ctx context.Context, key string, window time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	err = s.exec(ctx, tx, `INSERT INTO counters (id, count, expires_at) VALUES (?, 1, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			count = CASE WHEN counters.expires_at < ? THEN 1 ELSE counters.count + 1 END,
+			expires_at = CASE WHEN counters.expires_at < ? THEN excluded.expires_at ELSE counters.expires_at END`,
+		key, now.Add(window).Unix(), now.Unix(), now.Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, s.rebind(`SELECT count FROM counters WHERE id = ?`), key).Scan(&count); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// SaveDeadLetter parks the failed message
+func (s *SQL) SaveDeadLetter(ctx context.Context, msg *types.DeadLetter) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO dead_letters (id, path, created_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET path = excluded.path, created_at = excluded.created_at, data = excluded.data`,
+		msg.ID, msg.Path, msg.CreatedAt.Unix(), string(data))
+}
+
+// GetDeadLetter returns single parked message
+func (s *SQL) GetDeadLetter(ctx context.Context, id string) (*types.DeadLetter, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT data FROM dead_letters WHERE id = ?`), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg types.DeadLetter
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ListDeadLetters returns parked messages matching the query
+func (s *SQL) ListDeadLetters(ctx context.Context, q DeadLetterQuery) ([]types.DeadLetter, error) {
+	query := `SELECT data FROM dead_letters WHERE 1 = 1`
+	var args []interface{}
+	if q.Path != "" {
+		query += ` AND path = ?`
+		args = append(args, q.Path)
+	}
+	query += ` ORDER BY created_at DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []types.DeadLetter{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var msg types.DeadLetter
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteDeadLetter deletes single parked message
+func (s *SQL) DeleteDeadLetter(ctx context.Context, id string) error {
+	return s.exec(ctx, s.db, `DELETE FROM dead_letters WHERE id = ?`, id)
+}
+
+// PurgeDeadLetters deletes messages parked before given time
+func (s *SQL) PurgeDeadLetters(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM dead_letters WHERE created_at < ?`), before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// SavePendingNotification holds notification until its DueAt
+func (s *SQL) SavePendingNotification(ctx context.Context, n *types.PendingNotification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO pending_notifications (id, due_at, data) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET due_at = excluded.due_at, data = excluded.data`,
+		n.ID, n.DueAt.Unix(), string(data))
+}
+
+// ListDuePendingNotifications returns notifications due at or before given time, oldest first
+func (s *SQL) ListDuePendingNotifications(ctx context.Context, before time.Time, limit int) ([]types.PendingNotification, error) {
+	query := `SELECT data FROM pending_notifications WHERE due_at <= ? ORDER BY due_at`
+	args := []interface{}{before.Unix()}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []types.PendingNotification{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var n types.PendingNotification
+		if err := json.Unmarshal([]byte(data), &n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// DeletePendingNotification deletes single pending notification
+func (s *SQL) DeletePendingNotification(ctx context.Context, id string) error {
+	return s.exec(ctx, s.db, `DELETE FROM pending_notifications WHERE id = ?`, id)
+}
+
+// AddNotification adds notification to user's inbox
+func (s *SQL) AddNotification(ctx context.Context, userID string, n *types.Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, s.db, `INSERT INTO notifications (user_id, id, created_at, is_read, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, id) DO NOTHING`,
+		userID, n.ID, n.CreatedAt.UnixNano()/int64(time.Millisecond), boolInt(n.Read), string(data))
+}
+
+// ListNotifications returns user's inbox notifications, newest first
+func (s *SQL) ListNotifications(ctx context.Context, q NotificationQuery) ([]types.Notification, error) {
+	query := `SELECT is_read, data FROM notifications WHERE user_id = ?`
+	args := []interface{}{q.UserID}
+	if q.UnreadOnly {
+		query += ` AND is_read = 0`
+	}
+	if q.CursorID != "" {
+		query += ` AND (created_at < ? OR (created_at = ? AND id < ?))`
+		args = append(args, q.Cursor, q.Cursor, q.CursorID)
+	} else if q.Cursor > 0 {
+		query += ` AND created_at < ?`
+		args = append(args, q.Cursor)
+	}
+	query += ` ORDER BY created_at DESC, id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.Notification{}
+	for rows.Next() {
+		var read int
+		var data string
+		if err := rows.Scan(&read, &data); err != nil {
+			return nil, err
+		}
+		var n types.Notification
+		if err := json.Unmarshal([]byte(data), &n); err != nil {
+			return nil, err
+		}
+		n.Read = read == 1
+		items = append(items, n)
+	}
+	return items, rows.Err()
+}
+
+// CountUnreadNotifications returns number of unread notifications in user's inbox
+func (s *SQL) CountUnreadNotifications(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND is_read = 0`), userID).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationsRead marks notifications as read, empty ids means all user's notifications
+func (s *SQL) MarkNotificationsRead(ctx context.Context, userID string, ids []string) error {
+	return s.updateNotifications(ctx, `UPDATE notifications SET is_read = 1 WHERE user_id = ?`, userID, ids)
+}
+
+// DeleteNotifications deletes notifications from user's inbox, empty ids means all user's notifications
+func (s *SQL) DeleteNotifications(ctx context.Context, userID string, ids []string) error {
+	return s.updateNotifications(ctx, `DELETE FROM notifications WHERE user_id = ?`, userID, ids)
+}
+
+// updateNotifications runs query of user's notifications once, or once per id when ids is not empty
+func (s *SQL) updateNotifications(ctx context.Context, query, userID string, ids []string) error {
+	if len(ids) == 0 {
+		return s.exec(ctx, s.db, query, userID)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.exec(ctx, tx, query+` AND id = ?`, userID, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordDelivery saves delivery record and adds it to the daily stats
+func (s *SQL) RecordDelivery(ctx context.Context, day string, d *types.Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = s.exec(ctx, tx, `INSERT INTO deliveries (id, user_id, entry_id, response_id, created_at, data) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, entry_id = excluded.entry_id,
+			response_id = excluded.response_id, created_at = excluded.created_at, data = excluded.data`,
+		d.ID, d.UserID, d.EntryID, d.ResponseID, d.CreatedAt.Unix(), string(data))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = s.exec(ctx, tx, `INSERT INTO delivery_stats (day, data_type, category, notifications, tokens, sent, pruned, failed)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+		ON CONFLICT (day, data_type, category) DO UPDATE SET
+			notifications = delivery_stats.notifications + 1,
+			tokens = delivery_stats.tokens + excluded.tokens,
+			sent = delivery_stats.sent + excluded.sent,
+			pruned = delivery_stats.pruned + excluded.pruned,
+			failed = delivery_stats.failed + excluded.failed`,
+		day, d.DataType, d.Category, d.Tokens, d.Sent, d.Pruned, d.Failed)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDeliveries returns delivery records, newest first
+func (s *SQL) ListDeliveries(ctx context.Context, q DeliveryQuery) ([]types.Delivery, error) {
+	query := `SELECT data FROM deliveries WHERE 1 = 1`
+	var args []interface{}
+	if q.UserID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, q.UserID)
+	}
+	if q.EntryID != "" {
+		query += ` AND entry_id = ?`
+		args = append(args, q.EntryID)
+	}
+	if q.ResponseID != "" {
+		query += ` AND response_id = ?`
+		args = append(args, q.ResponseID)
+	}
+	query += ` ORDER BY created_at DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.Delivery{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var d types.Delivery
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return nil, err
+		}
+		items = append(items, d)
+	}
+	return items, rows.Err()
+}
+
+// ListDeliveryStats returns daily delivery stats from day to day
+func (s *SQL) ListDeliveryStats(ctx context.Context, from, to string) ([]types.DeliveryStats, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT day, data_type, category, notifications, tokens, sent, pruned, failed
+		FROM delivery_stats WHERE day >= ? AND day <= ? ORDER BY day, data_type, category`), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.DeliveryStats{}
+	for rows.Next() {
+		var st types.DeliveryStats
+		if err := rows.Scan(&st.Day, &st.DataType, &st.Category, &st.Notifications, &st.Tokens, &st.Sent, &st.Pruned, &st.Failed); err != nil {
+			return nil, err
+		}
+		items = append(items, st)
+	}
+	return items, rows.Err()
+}
+
+// boolInt returns 1 for true, booleans are stored as integer in both sqlite and postgres
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// RunTransaction runs fn inside SQL transaction
+func (s *SQL) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, &sqlTx{s: s, tx: tx, ctx: ctx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlTx implements Tx
+type sqlTx struct {
+	s   *SQL
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+func (t *sqlTx) GetResponse(id string) (*types.Response, error) {
+	var data string
+	err := t.tx.QueryRowContext(t.ctx, t.s.rebind(`SELECT data FROM entry_responses WHERE id = ?`), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r types.Response
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, err
+	}
+	r.ID = id
+	return &r, nil
+}
+
+func (t *sqlTx) IncrementEntry(collection, id string, counters map[string]int) error {
+	var exists int
+	err := t.tx.QueryRowContext(t.ctx, t.s.rebind(`SELECT 1 FROM entries WHERE collection = ? AND id = ?`), collection, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for name, value := range counters {
+		err := t.s.exec(t.ctx, t.tx, `INSERT INTO entry_counters (collection, entry_id, name, value) VALUES (?, ?, ?, ?)
+			ON CONFLICT (collection, entry_id, name) DO UPDATE SET value = entry_counters.value + excluded.value`,
+			collection, id, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) IncrementResponse(id string, counters map[string]int) error {
+	var exists int
+	err := t.tx.QueryRowContext(t.ctx, t.s.rebind(`SELECT 1 FROM entry_responses WHERE id = ?`), id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for name, value := range counters {
+		err := t.s.exec(t.ctx, t.tx, `INSERT INTO response_counters (response_id, name, value) VALUES (?, ?, ?)
+			ON CONFLICT (response_id, name) DO UPDATE SET value = response_counters.value + excluded.value`,
+			id, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) ClaimMessage(id string, ttl time.Duration) (bool, error) {
+	// single statement so concurrent claims can't both win,
+	// expired record is taken over, otherwise nothing is affected.
+	now := time.Now()
+	res, err := t.tx.ExecContext(t.ctx, t.s.rebind(`INSERT INTO processed_messages (id, expires_at) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at
+		WHERE processed_messages.expires_at < ?`),
+		id, now.Add(ttl).Unix(), now.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
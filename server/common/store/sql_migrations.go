@@ -0,0 +1,163 @@
+package store
+
+// migrations is the list of SQL schema migrations, each item is one version.
+// Only append new versions, never modify the ones that already released.
+// Statements must be valid on both SQLite and Postgres.
+var migrations = [][]string{
+	// 1: initial schema
+	{
+		`CREATE TABLE categories (
+			id TEXT PRIMARY KEY,
+			title TEXT NOT NULL
+		)`,
+		`CREATE TABLE feeds (
+			id BIGINT PRIMARY KEY,
+			category_id BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE entries (
+			collection TEXT NOT NULL,
+			id TEXT NOT NULL,
+			entry_id BIGINT NOT NULL,
+			feed_id BIGINT NOT NULL,
+			category_id BIGINT NOT NULL,
+			published_at BIGINT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (collection, id)
+		)`,
+		`CREATE INDEX entries_published_at_idx ON entries (collection, published_at)`,
+		`CREATE INDEX entries_category_idx ON entries (collection, category_id, published_at)`,
+		`CREATE TABLE entry_counters (
+			collection TEXT NOT NULL,
+			entry_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (collection, entry_id, name)
+		)`,
+		`CREATE TABLE entry_responses (
+			id TEXT PRIMARY KEY,
+			entry_id BIGINT NOT NULL,
+			user_id TEXT NOT NULL,
+			type TEXT NOT NULL,
+			parent_id TEXT NOT NULL DEFAULT '',
+			thread_id TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX entry_responses_thread_idx ON entry_responses (thread_id)`,
+		`CREATE INDEX entry_responses_parent_idx ON entry_responses (parent_id)`,
+		`CREATE TABLE response_counters (
+			response_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (response_id, name)
+		)`,
+		`CREATE TABLE subscribers (
+			category_id TEXT NOT NULL,
+			id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (category_id, id)
+		)`,
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			fcm_tokens TEXT NOT NULL DEFAULT '{}'
+		)`,
+	},
+	// 2: entry index by Miniflux ID, responses by entry
+	{
+		`CREATE TABLE entry_index (
+			miniflux_id BIGINT PRIMARY KEY,
+			collection TEXT NOT NULL,
+			id TEXT NOT NULL
+		)`,
+		`CREATE INDEX entry_responses_entry_idx ON entry_responses (entry_id)`,
+	},
+	// 3: processed-message ledger
+	{
+		`CREATE TABLE processed_messages (
+			id TEXT PRIMARY KEY,
+			expires_at BIGINT NOT NULL
+		)`,
+	},
+	// 4: delivery attempts and dead letters
+	{
+		`CREATE TABLE message_attempts (
+			id TEXT PRIMARY KEY,
+			attempts INTEGER NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE dead_letters (
+			id TEXT PRIMARY KEY,
+			path TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX dead_letters_created_at_idx ON dead_letters (created_at)`,
+	},
+	// 5: user notification preferences and pending notifications
+	{
+		`ALTER TABLE users ADD COLUMN preferences TEXT NOT NULL DEFAULT 'null'`,
+		`CREATE TABLE pending_notifications (
+			id TEXT PRIMARY KEY,
+			due_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX pending_notifications_due_at_idx ON pending_notifications (due_at)`,
+	},
+	// 6: users notifications inbox
+	{
+		`CREATE TABLE notifications (
+			user_id TEXT NOT NULL,
+			id TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			is_read INTEGER NOT NULL DEFAULT 0,
+			data TEXT NOT NULL,
+			PRIMARY KEY (user_id, id)
+		)`,
+		`CREATE INDEX notifications_user_created_at_idx ON notifications (user_id, created_at)`,
+	},
+	// 7: push notification delivery records and daily stats
+	{
+		`CREATE TABLE deliveries (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			entry_id TEXT NOT NULL,
+			response_id TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX deliveries_entry_idx ON deliveries (entry_id, created_at)`,
+		`CREATE INDEX deliveries_user_idx ON deliveries (user_id, created_at)`,
+		`CREATE TABLE delivery_stats (
+			day TEXT NOT NULL,
+			data_type TEXT NOT NULL,
+			category TEXT NOT NULL,
+			notifications INTEGER NOT NULL,
+			tokens INTEGER NOT NULL,
+			sent INTEGER NOT NULL,
+			pruned INTEGER NOT NULL,
+			failed INTEGER NOT NULL,
+			PRIMARY KEY (day, data_type, category)
+		)`,
+	},
+	// 8: entry responses creation time, to list comments
+	{
+		`ALTER TABLE entry_responses ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0`,
+		`CREATE INDEX entry_responses_comments_idx ON entry_responses (entry_id, thread_id, created_at)`,
+	},
+	// 9: fixed window counters
+	{
+		`CREATE TABLE counters (
+			id TEXT PRIMARY KEY,
+			count INTEGER NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+	},
+	// 10: users subscribed to FCM topics
+	{
+		`CREATE TABLE topic_users (
+			topic TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (topic, user_id)
+		)`,
+	},
+}
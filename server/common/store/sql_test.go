@@ -0,0 +1,17 @@
+package store
+
+import "testing"
+
+func TestSQLRebind(t *testing.T) {
+	query := `SELECT data FROM entries WHERE collection = ? AND id = ?`
+
+	s := &SQL{driver: "sqlite3"}
+	if s.rebind(query) != query {
+		t.Error("SQLite query should not be rebinded")
+	}
+
+	s = &SQL{driver: "postgres"}
+	if s.rebind(query) != `SELECT data FROM entries WHERE collection = $1 AND id = $2` {
+		t.Error("Wrong Postgres placeholders")
+	}
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"server/common/types"
+)
+
+// ErrNotFound is returned when the requested document does not exist.
+var ErrNotFound = errors.New("store: document not found")
+
+// ErrAlreadyExists is returned when creating a document whose ID is already taken.
+var ErrAlreadyExists = errors.New("store: document already exists")
+
+// EntryQuery is the options to list entries of a collection.
+type EntryQuery struct {
+	Collection string
+	Cursor     int64 // published_at of the last entry of previous page
+	CategoryID int64
+	Limit      int
+}
+
+// ResponseQuery is the options to list entry responses,
+// only non-empty field will be used as filter.
+type ResponseQuery struct {
+	ThreadID string
+	ParentID string
+	EntryID  int64
+	UserID   string
+	Type     string // "COMMENT" or "REACTION"
+}
+
+// CommentQuery is the options to list top level comments of an entry, newest first.
+type CommentQuery struct {
+	EntryID  int64
+	Cursor   int64  // created_at of the last comment of previous page
+	CursorID string // ID of the last comment of previous page, orders comments with the same created_at
+	Limit    int
+}
+
+// ReplyQuery is the options to list replies of a thread, oldest first.
+type ReplyQuery struct {
+	ThreadID string
+	Limit    int
+}
+
+// DeadLetterQuery is the options to list dead letters, newest first.
+type DeadLetterQuery struct {
+	Path  string // only messages of this handler, eg. "/pubsub/sync-data"
+	Limit int
+}
+
+// NotificationQuery is the options to list user's inbox notifications, newest first.
+type NotificationQuery struct {
+	UserID     string
+	Cursor     int64  // created_at (unix millisecs) of the last notification of previous page
+	CursorID   string // ID of the last notification of previous page, orders notifications with the same created_at
+	UnreadOnly bool
+	Limit      int
+}
+
+// DeliveryQuery is the options to list delivery records, newest first,
+// only non-empty field will be used as filter.
+type DeliveryQuery struct {
+	UserID     string
+	EntryID    string
+	ResponseID string
+	Limit      int
+}
+
+// EntryRef is the location of an entry document,
+// entries are indexed by Miniflux entry ID since some collections key them by PublishedAt.
+type EntryRef struct {
+	Collection string `json:"collection" firestore:"collection"`
+	ID         string `json:"id" firestore:"id"`
+}
+
+// Tx is the set of operations that can be done inside a transaction.
+// Reads must be done before any writes.
+type Tx interface {
+	// GetResponse returns single entry response
+	GetResponse(id string) (*types.Response, error)
+	// IncrementEntry increments numeric fields of an entry, counters is field name to increment value
+	IncrementEntry(collection, id string, counters map[string]int) error
+	// IncrementResponse increments numeric fields of an entry response
+	IncrementResponse(id string, counters map[string]int) error
+	// ClaimMessage is Store.ClaimMessage inside the transaction, the record is written on commit
+	// so it's rolled back together with the other writes.
+	ClaimMessage(id string, ttl time.Duration) (bool, error)
+}
+
+// Store is the storage used by handlers to read and write
+// categories, feeds, entries, entry responses, subscribers and users.
+type Store interface {
+	// GetCategory returns single category, id is not always numeric (eg. "balebengong")
+	GetCategory(ctx context.Context, id string) (*types.Category, error)
+	// ListCategories returns all categories
+	ListCategories(ctx context.Context) ([]types.Category, error)
+	// SaveCategories creates or replaces categories
+	SaveCategories(ctx context.Context, categories []types.Category) error
+	// DeleteCategory deletes single category
+	DeleteCategory(ctx context.Context, id int64) error
+
+	// ListFeeds returns all feeds
+	ListFeeds(ctx context.Context) ([]types.Feed, error)
+	// SaveFeed creates or replaces a feed
+	SaveFeed(ctx context.Context, feed *types.Feed) error
+	// DeleteFeed deletes single feed
+	DeleteFeed(ctx context.Context, id int64) error
+
+	// GetEntry returns single entry of a collection including its aggregated counters
+	GetEntry(ctx context.Context, collection, id string) (map[string]interface{}, error)
+	// ListEntries returns entries ordered by published_at descending
+	ListEntries(ctx context.Context, q EntryQuery) ([]map[string]interface{}, error)
+	// SaveEntry creates or replaces an entry of a collection
+	SaveEntry(ctx context.Context, collection, id string, entry *types.Entry) error
+	// CountEntries returns number of entries in a collection
+	CountEntries(ctx context.Context, collection string) (int, error)
+	// DeleteEntry deletes single entry of a collection
+	DeleteEntry(ctx context.Context, collection, id string) error
+
+	// GetEntryRef returns the location of entry with given Miniflux ID
+	GetEntryRef(ctx context.Context, minifluxID int64) (*EntryRef, error)
+	// SaveEntryRef creates or replaces the location of entry with given Miniflux ID
+	SaveEntryRef(ctx context.Context, minifluxID int64, ref *EntryRef) error
+	// DeleteEntryRef deletes the location of entry with given Miniflux ID
+	DeleteEntryRef(ctx context.Context, minifluxID int64) error
+
+	// GetResponse returns single entry response
+	GetResponse(ctx context.Context, id string) (*types.Response, error)
+	// SaveResponse creates or replaces an entry response
+	SaveResponse(ctx context.Context, r *types.Response) error
+	// CreateResponse creates an entry response, returns ErrAlreadyExists when its ID is taken
+	CreateResponse(ctx context.Context, r *types.Response) error
+	// UpdateResponse sets top level fields of an entry response, fields is field name to value.
+	// Other fields (eg. reply_count) are kept, returns ErrNotFound when the response doesn't exist.
+	UpdateResponse(ctx context.Context, id string, fields map[string]interface{}) error
+	// ListResponses returns entry responses matching the query
+	ListResponses(ctx context.Context, q ResponseQuery) ([]types.Response, error)
+	// ListComments returns top level comments (without thread) of an entry
+	ListComments(ctx context.Context, q CommentQuery) ([]types.Response, error)
+	// ListReplies returns comments in a thread, including replies of replies
+	ListReplies(ctx context.Context, q ReplyQuery) ([]types.Response, error)
+	// DeleteResponses deletes entry responses by their IDs
+	DeleteResponses(ctx context.Context, ids []string) error
+
+	// ListSubscribers returns subscribers of a category
+	ListSubscribers(ctx context.Context, categoryID string) ([]types.Subscriber, error)
+	// DeleteSubscriber removes a subscriber from a category
+	DeleteSubscriber(ctx context.Context, categoryID, subscriberID string) error
+	// ListTopicUsers returns IDs of users whose devices are subscribed to FCM topic
+	ListTopicUsers(ctx context.Context, topic string) ([]string, error)
+	// SetTopicUser records the user whose devices are subscribed to FCM topic, or removes it when not subscribed
+	SetTopicUser(ctx context.Context, topic, userID string, subscribed bool) error
+
+	// GetUser returns single user
+	GetUser(ctx context.Context, id string) (*types.User, error)
+	// SetUserTokens replaces user's FCM tokens
+	SetUserTokens(ctx context.Context, id string, tokens map[string]interface{}) error
+
+	// ClaimMessage records message id in the processed-message ledger for ttl,
+	// returns false when it's already recorded and not expired yet.
+	ClaimMessage(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// ReleaseMessage removes message id from the processed-message ledger
+	ReleaseMessage(ctx context.Context, id string) error
+
+	// IncrementAttempts increments and returns delivery attempts of message id, the counter expires after ttl.
+	// Used when Pub/Sub doesn't send deliveryAttempt (subscription without dead letter policy)
+	IncrementAttempts(ctx context.Context, id string, ttl time.Duration) (int, error)
+	// IncrementCounter increments and returns the counter of key within a fixed window,
+	// the window starts at the first increment and the counter restarts from 1 after it ends.
+	IncrementCounter(ctx context.Context, key string, window time.Duration) (int, error)
+	// SaveDeadLetter parks the failed message
+	SaveDeadLetter(ctx context.Context, msg *types.DeadLetter) error
+	// GetDeadLetter returns single parked message
+	GetDeadLetter(ctx context.Context, id string) (*types.DeadLetter, error)
+	// ListDeadLetters returns parked messages matching the query
+	ListDeadLetters(ctx context.Context, q DeadLetterQuery) ([]types.DeadLetter, error)
+	// DeleteDeadLetter deletes single parked message
+	DeleteDeadLetter(ctx context.Context, id string) error
+	// PurgeDeadLetters deletes messages parked before given time, returns number of deleted messages
+	PurgeDeadLetters(ctx context.Context, before time.Time) (int, error)
+
+	// SavePendingNotification holds notification until its DueAt, replaces the one with the same ID
+	SavePendingNotification(ctx context.Context, n *types.PendingNotification) error
+	// ListDuePendingNotifications returns notifications due at or before given time, oldest first
+	ListDuePendingNotifications(ctx context.Context, before time.Time, limit int) ([]types.PendingNotification, error)
+	// DeletePendingNotification deletes single pending notification
+	DeletePendingNotification(ctx context.Context, id string) error
+
+	// AddNotification adds notification to user's inbox, existing notification with the same ID is kept
+	AddNotification(ctx context.Context, userID string, n *types.Notification) error
+	// ListNotifications returns user's inbox notifications matching the query
+	ListNotifications(ctx context.Context, q NotificationQuery) ([]types.Notification, error)
+	// CountUnreadNotifications returns number of unread notifications in user's inbox
+	CountUnreadNotifications(ctx context.Context, userID string) (int, error)
+	// MarkNotificationsRead marks notifications as read, empty ids means all user's notifications.
+	// Unknown ids are ignored.
+	MarkNotificationsRead(ctx context.Context, userID string, ids []string) error
+	// DeleteNotifications deletes notifications from user's inbox, empty ids means all user's notifications
+	DeleteNotifications(ctx context.Context, userID string, ids []string) error
+
+	// RecordDelivery saves push notification delivery record, replacing the one with the same ID,
+	// and adds it to the daily stats of its day, data type and category.
+	RecordDelivery(ctx context.Context, day string, d *types.Delivery) error
+	// ListDeliveries returns delivery records matching the query
+	ListDeliveries(ctx context.Context, q DeliveryQuery) ([]types.Delivery, error)
+	// ListDeliveryStats returns daily delivery stats from day to day (inclusive, "2006-01-02")
+	ListDeliveryStats(ctx context.Context, from, to string) ([]types.DeliveryStats, error)
+
+	// RunTransaction runs fn inside a transaction,
+	// fn may be called more than once so it should not have side effects.
+	RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error
+}
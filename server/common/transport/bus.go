@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"server/common/fault"
+)
+
+// Bus is in-process Publisher and Subscriber, topic and subscription are the same thing.
+// Used to run all handlers in one binary without GCP.
+// Messages are kept in memory, retryable failures are redelivered up to MaxAttempts.
+type Bus struct {
+	// MaxAttempts is number of delivery attempts before message is dropped
+	MaxAttempts int
+	// RetryDelay is the delay before redelivery, multiplied by the attempt
+	RetryDelay time.Duration
+
+	mu      sync.Mutex
+	topics  map[string]chan *Message
+	counter uint64
+}
+
+// NewBus returns Bus instance
+func NewBus() *Bus {
+	return &Bus{MaxAttempts: 5, RetryDelay: time.Second, topics: make(map[string]chan *Message)}
+}
+
+// topic returns the queue of topic, created on first use so messages published
+// before the subscriber starts are not lost.
+func (b *Bus) topic(name string) chan *Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch, ok := b.topics[name]
+	if !ok {
+		ch = make(chan *Message, 100)
+		b.topics[name] = ch
+	}
+	return ch
+}
+
+// Publish queues msg to topic
+func (b *Bus) Publish(ctx context.Context, topic string, msg *Message) (string, error) {
+	m := *msg
+	m.ID = fmt.Sprintf("bus-%d", atomic.AddUint64(&b.counter, 1))
+	m.DeliveryAttempt = 1
+
+	select {
+	case b.topic(topic) <- &m:
+		return m.ID, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Subscribe calls h for every message of topic, blocks until ctx is done.
+// Multiple subscribers of the same topic compete for messages.
+func (b *Bus) Subscribe(ctx context.Context, topic string, h Handler) error {
+	ch := b.topic(topic)
+	for {
+		select {
+		case msg := <-ch:
+			b.deliver(ctx, topic, msg, h)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (b *Bus) deliver(ctx context.Context, topic string, msg *Message, h Handler) {
+	err := h(ctx, msg)
+	if err == nil {
+		return
+	}
+	if !fault.IsRetryable(err) || msg.DeliveryAttempt >= b.MaxAttempts {
+		log.Printf("[ERROR] drop message %s of %s after %d attempts: %s\n", msg.ID, topic, msg.DeliveryAttempt, err)
+		return
+	}
+
+	log.Printf("[RETRY] attempt %d of message %s of %s: %s\n", msg.DeliveryAttempt, msg.ID, topic, err)
+	retry := *msg
+	retry.DeliveryAttempt++
+	time.AfterFunc(b.RetryDelay*time.Duration(msg.DeliveryAttempt), func() {
+		b.topic(topic) <- &retry
+	})
+}
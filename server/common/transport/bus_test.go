@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"server/common/fault"
+)
+
+func TestBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewBus()
+	bus.RetryDelay = time.Millisecond
+
+	// published before subscribing must not be lost
+	bus.Publish(ctx, "topic", &Message{Data: []byte("retry")})
+	bus.Publish(ctx, "topic", &Message{Data: []byte("drop")})
+	bus.Publish(ctx, "other", &Message{Data: []byte("other")})
+
+	attempts := make(chan *Message, 10)
+	go bus.Subscribe(ctx, "topic", func(ctx context.Context, msg *Message) error {
+		attempts <- msg
+		if string(msg.Data) == "drop" {
+			return fault.Permanent(errors.New("bad payload"))
+		}
+		if msg.DeliveryAttempt < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	got := map[string]int{}
+	timeout := time.After(time.Second)
+	for got["retry"] < 3 {
+		select {
+		case msg := <-attempts:
+			got[string(msg.Data)]++
+			if msg.ID == "" {
+				t.Error("Message ID should be set")
+			}
+		case <-timeout:
+			t.Fatal("Timeout, got", got)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got["drop"] != 1 || got["other"] != 0 || len(attempts) != 0 {
+		t.Error("Wrong deliveries:", got, len(attempts))
+	}
+}
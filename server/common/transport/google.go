@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/pubsub"
+
+	"server/common/fault"
+	"server/common/service"
+)
+
+// Google is Publisher and Subscriber on Google Cloud Pub/Sub
+type Google struct {
+	// ReceiveSettings is the flow control of Subscribe, zero values use Pub/Sub defaults
+	ReceiveSettings pubsub.ReceiveSettings
+
+	google *service.Google
+}
+
+// NewGoogle returns Google instance
+func NewGoogle(g *service.Google) *Google {
+	return &Google{google: g}
+}
+
+// Publish publishes msg to Pub/Sub topic
+func (g *Google) Publish(ctx context.Context, topic string, msg *Message) (string, error) {
+	return g.google.PublishToTopic(ctx, topic, &pubsub.Message{Data: msg.Data, Attributes: msg.Attributes})
+}
+
+// Subscribe pulls messages of Pub/Sub subscription,
+// message is acked when handled or failed permanently, otherwise nacked so Pub/Sub redelivers it.
+func (g *Google) Subscribe(ctx context.Context, subscription string, h Handler) error {
+	if err := g.google.InitPubsub(ctx); err != nil {
+		return err
+	}
+	sub := g.google.Pubsub.Subscription(subscription)
+	sub.ReceiveSettings = g.ReceiveSettings
+	return sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		err := h(ctx, &Message{ID: m.ID, Data: m.Data, Attributes: m.Attributes})
+		if err != nil && fault.IsRetryable(err) {
+			log.Printf("[RETRY] message %s of %s: %s\n", m.ID, subscription, err)
+			m.Nack()
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] message %s of %s: %s\n", m.ID, subscription, err)
+		}
+		m.Ack()
+	})
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	pubs "github.com/fiberweb/pubsub"
+	"github.com/gofiber/fiber"
+)
+
+// AttemptKey is Fiber Locals key of the delivery attempt of PubSub push message, when it's counted before handling
+const AttemptKey = "PubSubDeliveryAttempt"
+
+// Message is the transport independent message
+type Message struct {
+	ID         string
+	Data       []byte
+	Attributes map[string]string
+	// DeliveryAttempt starts from 1, 0 means unknown
+	DeliveryAttempt int
+}
+
+// Handler processes single message, returned error is classified by fault.IsRetryable
+// to decide whether the message is redelivered.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Publisher publishes message to a topic
+type Publisher interface {
+	// Publish publishes msg to topic and returns the message ID
+	Publish(ctx context.Context, topic string, msg *Message) (string, error)
+}
+
+// Subscriber delivers messages of a subscription to handler
+type Subscriber interface {
+	// Subscribe calls h for every message of subscription, blocks until ctx is done
+	Subscribe(ctx context.Context, subscription string, h Handler) error
+}
+
+// FromPush converts message received by PubSub push middleware, with the delivery attempt of the request
+func FromPush(c *fiber.Ctx, msg *pubs.Message) *Message {
+	attrs := make(map[string]string, len(msg.Message.Attributes))
+	for k, v := range msg.Message.Attributes {
+		attrs[k] = fmt.Sprint(v)
+	}
+	return &Message{ID: msg.Message.ID, Data: msg.Message.Data, Attributes: attrs, DeliveryAttempt: PushAttempt(c)}
+}
+
+// PushAttempt returns the delivery attempt counted in c Locals, or deliveryAttempt of the push request
+// which PubSub only sends when the subscription has dead letter policy. Returns 0 when unknown.
+func PushAttempt(c *fiber.Ctx) int {
+	if attempt, ok := c.Locals(AttemptKey).(int); ok {
+		return attempt
+	}
+	var envelope struct {
+		DeliveryAttempt int `json:"deliveryAttempt"`
+	}
+	if json.Unmarshal([]byte(c.Body()), &envelope) != nil {
+		return 0
+	}
+	return envelope.DeliveryAttempt
+}
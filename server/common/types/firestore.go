@@ -58,3 +58,44 @@ type Entry struct {
 	PublishedAt int64        `json:"published_at" firestore:"published_at"`
 	Categories  []int64      `json:"categories" firestore:"categories"` // Deprecated: to support legacy app.
 }
+
+// ResponseUser is the author of a Response
+type ResponseUser struct {
+	ID     string `json:"id" firestore:"id"`
+	Name   string `json:"name" firestore:"name"`
+	Avatar string `json:"avatar" firestore:"avatar"`
+}
+
+// Response represents entry response (comment or reaction) Firestore document
+type Response struct {
+	ID              string       `json:"id,omitempty" firestore:"-"`
+	UserID          string       `json:"user_id" firestore:"user_id"`
+	Type            string       `json:"type" firestore:"type"`
+	EntryID         int64        `json:"entry_id" firestore:"entry_id"`
+	EntryCategoryID int64        `json:"entry_category_id" firestore:"entry_category_id"`
+	EntryFeedID     int64        `json:"entry_feed_id" firestore:"entry_feed_id"`
+	ParentID        string       `json:"parent_id" firestore:"parent_id"` // level 0 or level n
+	ThreadID        string       `json:"thread_id" firestore:"thread_id"` // level 0
+	Reaction        string       `json:"reaction" firestore:"reaction"`
+	Comment         string       `json:"comment" firestore:"comment"`
+	Entry           Entry        `json:"entry" firestore:"entry"`
+	User            ResponseUser `json:"user" firestore:"user"`
+	// ReplyCount is number of replies of the comment, aggregated by events handler
+	ReplyCount int64 `json:"reply_count" firestore:"reply_count"`
+	// CreatedAt is in millisecs, zero on responses written before it was added
+	CreatedAt int64 `json:"created_at" firestore:"created_at"`
+}
+
+// Subscriber represents a document in categories/{id}/subscribers
+type Subscriber struct {
+	ID     string `json:"-" firestore:"-"`
+	UserID string `json:"user_id" firestore:"user_id"`
+}
+
+// User represents user Firestore document
+type User struct {
+	ID        string                 `json:"-" firestore:"-"`
+	FCMTokens map[string]interface{} `json:"fcm_tokens" firestore:"fcm_tokens"`
+	// Preferences are written by the app, nil means default preferences
+	Preferences *NotificationPreferences `json:"notification_preferences,omitempty" firestore:"notification_preferences,omitempty"`
+}
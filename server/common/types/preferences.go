@@ -0,0 +1,121 @@
+package types
+
+import "time"
+
+// NotificationPreferences are user's push notification settings, nil means everything is enabled.
+// Categories are not applied to FCM topic notifications, they reach every device subscribed to the topic.
+type NotificationPreferences struct {
+	// Categories limits entry notifications to these subscriber categories, nil means all subscribed categories
+	Categories []string `json:"categories,omitempty" firestore:"categories,omitempty"`
+	// RepliesDisabled turns off notifications of replies to user's comments
+	RepliesDisabled bool `json:"replies_disabled,omitempty" firestore:"replies_disabled,omitempty"`
+	// QuietHours suppresses notifications daily, nil means disabled
+	QuietHours *QuietHours `json:"quiet_hours,omitempty" firestore:"quiet_hours,omitempty"`
+	// MaxPerHour limits notifications sent per hour, 0 means unlimited
+	MaxPerHour int `json:"max_per_hour,omitempty" firestore:"max_per_hour,omitempty"`
+	// Digest sends entry notifications as single notification per category on schedule, nil means disabled
+	Digest *Digest `json:"digest,omitempty" firestore:"digest,omitempty"`
+}
+
+// QuietHours is a daily time range in user's timezone, eg. 22:00 - 06:00
+type QuietHours struct {
+	// Start and End are "15:04" formatted, End before Start spans midnight
+	Start string `json:"start" firestore:"start"`
+	End   string `json:"end" firestore:"end"`
+	// Timezone is IANA timezone name, eg. "Asia/Makassar", default to the server timezone
+	Timezone string `json:"timezone,omitempty" firestore:"timezone,omitempty"`
+	// Defer delivers suppressed notifications when quiet hours end, otherwise they are dropped
+	Defer bool `json:"defer,omitempty" firestore:"defer,omitempty"`
+}
+
+// Digest is the schedule of entry notifications digest
+type Digest struct {
+	// Hours are hours of the day (0-23) digests are sent, eg. [7, 19] for morning and evening.
+	// Empty means hourly.
+	Hours []int `json:"hours,omitempty" firestore:"hours,omitempty"`
+	// Timezone is IANA timezone name, eg. "Asia/Makassar", default to the server timezone
+	Timezone string `json:"timezone,omitempty" firestore:"timezone,omitempty"`
+}
+
+// AllowsCategory reports whether user wants entry notifications of subscriber category
+func (p *NotificationPreferences) AllowsCategory(categoryID string) bool {
+	if p == nil || p.Categories == nil {
+		return true
+	}
+	for _, c := range p.Categories {
+		if c == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsReplies reports whether user wants notifications of replies to their comments
+func (p *NotificationPreferences) AllowsReplies() bool {
+	return p == nil || !p.RepliesDisabled
+}
+
+// QuietUntil returns the end of current quiet hours, or false when now is not in quiet hours.
+// def is the timezone used when Timezone is empty or unknown.
+func (q *QuietHours) QuietUntil(now time.Time, def *time.Location) (time.Time, bool) {
+	if q == nil {
+		return time.Time{}, false
+	}
+	loc := def
+	if q.Timezone != "" {
+		if l, err := time.LoadLocation(q.Timezone); err == nil {
+			loc = l
+		}
+	}
+	start, err1 := time.Parse("15:04", q.Start)
+	end, err2 := time.Parse("15:04", q.End)
+	if err1 != nil || err2 != nil || start.Equal(end) {
+		return time.Time{}, false
+	}
+
+	t := now.In(loc)
+	startAt := time.Date(t.Year(), t.Month(), t.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+	endAt := time.Date(t.Year(), t.Month(), t.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+
+	if startAt.Before(endAt) {
+		// same day, eg. 13:00 - 15:00
+		if !t.Before(startAt) && t.Before(endAt) {
+			return endAt, true
+		}
+		return time.Time{}, false
+	}
+	// spans midnight, eg. 22:00 - 06:00
+	if !t.Before(startAt) {
+		return endAt.AddDate(0, 0, 1), true
+	}
+	if t.Before(endAt) {
+		return endAt, true
+	}
+	return time.Time{}, false
+}
+
+// Next returns the next digest time after now.
+// def is the timezone used when Timezone is empty or unknown.
+func (d *Digest) Next(now time.Time, def *time.Location) time.Time {
+	loc := def
+	if d.Timezone != "" {
+		if l, err := time.LoadLocation(d.Timezone); err == nil {
+			loc = l
+		}
+	}
+	t := now.In(loc)
+	hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	if len(d.Hours) == 0 {
+		return hour.Add(time.Hour)
+	}
+	for i := 1; i <= 24; i++ {
+		next := hour.Add(time.Duration(i) * time.Hour)
+		for _, h := range d.Hours {
+			if next.Hour() == h {
+				return next
+			}
+		}
+	}
+	// no valid hours, send hourly
+	return hour.Add(time.Hour)
+}
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietUntil(t *testing.T) {
+	wita := time.FixedZone("WITA", 8*3600)
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 1, 10, hour, min, 0, 0, wita)
+	}
+
+	tests := []struct {
+		start, end string
+		now        time.Time
+		until      time.Time
+		quiet      bool
+	}{
+		{"22:00", "06:00", at(23, 0), time.Date(2020, 1, 11, 6, 0, 0, 0, wita), true},
+		{"22:00", "06:00", at(5, 59), at(6, 0), true},
+		{"22:00", "06:00", at(6, 0), time.Time{}, false},
+		{"13:00", "15:00", at(14, 0), at(15, 0), true},
+		{"13:00", "15:00", at(12, 0), time.Time{}, false},
+		{"13:00", "13:00", at(13, 0), time.Time{}, false},
+		{"invalid", "13:00", at(13, 0), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		q := &QuietHours{Start: tt.start, End: tt.end}
+		until, quiet := q.QuietUntil(tt.now, wita)
+		if quiet != tt.quiet || !until.Equal(tt.until) {
+			t.Errorf("QuietUntil(%s-%s, %s) = %s, %v, want %s, %v", tt.start, tt.end, tt.now, until, quiet, tt.until, tt.quiet)
+		}
+	}
+
+	// timezone of the preferences wins
+	q := &QuietHours{Start: "22:00", End: "06:00", Timezone: "UTC"}
+	if _, quiet := q.QuietUntil(at(23, 0), wita); quiet {
+		t.Error("23:00 WITA is 15:00 UTC, should not be quiet")
+	}
+}
+
+func TestAllowsCategory(t *testing.T) {
+	var p *NotificationPreferences
+	if !p.AllowsCategory("1") || !p.AllowsReplies() {
+		t.Error("nil preferences should allow everything")
+	}
+	p = &NotificationPreferences{Categories: []string{"1"}, RepliesDisabled: true}
+	if !p.AllowsCategory("1") || p.AllowsCategory("2") || p.AllowsReplies() {
+		t.Errorf("Unexpected preferences %+v", p)
+	}
+}
+
+func TestDigestNext(t *testing.T) {
+	wita := time.FixedZone("WITA", 8*3600)
+	now := time.Date(2020, 1, 10, 8, 30, 0, 0, wita)
+
+	tests := []struct {
+		hours []int
+		next  time.Time
+	}{
+		{nil, time.Date(2020, 1, 10, 9, 0, 0, 0, wita)},
+		{[]int{7, 19}, time.Date(2020, 1, 10, 19, 0, 0, 0, wita)},
+		{[]int{7}, time.Date(2020, 1, 11, 7, 0, 0, 0, wita)},
+		{[]int{24}, time.Date(2020, 1, 10, 9, 0, 0, 0, wita)},
+	}
+	for _, tt := range tests {
+		d := &Digest{Hours: tt.hours}
+		if next := d.Next(now, wita); !next.Equal(tt.next) {
+			t.Errorf("Next(%v) = %s, want %s", tt.hours, next, tt.next)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // SyncPayload is the pub/sub message payload after deserialized
 type SyncPayload struct {
 	ID   *int64  `json:"entity_id"`
@@ -8,11 +10,110 @@ type SyncPayload struct {
 }
 
 // PushNotificationPayload is the payload to send push notification.
+// Sent to all devices of UserID, or to FCM Topic when set.
 type PushNotificationPayload struct {
 	UserID      string            `json:"user_id"`
+	Topic       string            `json:"topic,omitempty"`
 	Title       string            `json:"title"`
 	Body        string            `json:"body"`
 	Image       string            `json:"image,omitempty"`
 	CollapseKey string            `json:"collapse_key,omitempty"`
 	Data        map[string]string `json:"data,omitempty"`
+	// Category is the subscriber category of entry notification, checked against user preferences
+	Category string `json:"category,omitempty"`
+	// APNS and Webpush are iOS and web specific options
+	APNS    *APNSOptions    `json:"apns,omitempty"`
+	Webpush *WebpushOptions `json:"webpush,omitempty"`
+}
+
+// APNSOptions are iOS specific push notification options
+type APNSOptions struct {
+	// MutableContent lets notification service extension download the image,
+	// always enabled when there is an image.
+	MutableContent bool `json:"mutable_content,omitempty"`
+	// Image overrides the payload image
+	Image string `json:"image,omitempty"`
+	// ThreadID groups notifications, eg. of the same entry
+	ThreadID string `json:"thread_id,omitempty"`
+	// Badge is the app icon badge number, nil leaves the badge unchanged
+	Badge *int `json:"badge,omitempty"`
+}
+
+// WebpushOptions are web specific push notification options
+type WebpushOptions struct {
+	Icon string `json:"icon,omitempty"`
+	// Image overrides the payload image
+	Image string `json:"image,omitempty"`
+	// Link is opened when the notification is clicked, must be HTTPS
+	Link string `json:"link,omitempty"`
+}
+
+// ReconcilePayload is the payload to trigger full reconciliation
+type ReconcilePayload struct {
+	Since  *int64 `json:"since,omitempty"` // unix timestamp, default to now - RECONCILE_WINDOW
+	DryRun bool   `json:"dry_run"`
+}
+
+// DeadLetter is a Pub/Sub message parked after too many failed delivery attempts
+type DeadLetter struct {
+	ID           string            `json:"id" firestore:"-"` // Pub/Sub message ID
+	Path         string            `json:"path" firestore:"path"`
+	Subscription string            `json:"subscription" firestore:"subscription"`
+	Data         []byte            `json:"data" firestore:"data"`
+	Attributes   map[string]string `json:"attributes,omitempty" firestore:"attributes,omitempty"`
+	Attempts     int               `json:"attempts" firestore:"attempts"`
+	Error        string            `json:"error" firestore:"error"`
+	CreatedAt    time.Time         `json:"created_at" firestore:"created_at"`
+}
+
+// PendingNotification is a push notification held until DueAt, eg. deferred by user's quiet hours or buffered for digest
+type PendingNotification struct {
+	ID        string                  `json:"id" firestore:"-"`
+	UserID    string                  `json:"user_id" firestore:"user_id"`
+	DigestKey string                  `json:"digest_key,omitempty" firestore:"digest_key,omitempty"` // user_category of buffered digest entry
+	Payload   PushNotificationPayload `json:"payload" firestore:"payload"`
+	DueAt     time.Time               `json:"due_at" firestore:"due_at"`
+	CreatedAt time.Time               `json:"created_at" firestore:"created_at"`
+}
+
+// Notification is a push notification kept in user's inbox (users/{id}/notifications)
+type Notification struct {
+	ID        string            `json:"id" firestore:"-"`
+	Title     string            `json:"title" firestore:"title"`
+	Body      string            `json:"body" firestore:"body"`
+	Image     string            `json:"image,omitempty" firestore:"image,omitempty"`
+	Data      map[string]string `json:"data,omitempty" firestore:"data,omitempty"`
+	Read      bool              `json:"read" firestore:"read"`
+	CreatedAt time.Time         `json:"created_at" firestore:"created_at"`
+}
+
+// Delivery is the audit record of push notification send
+type Delivery struct {
+	ID       string `json:"id" firestore:"-"`
+	UserID   string `json:"user_id,omitempty" firestore:"user_id,omitempty"`
+	Topic    string `json:"topic,omitempty" firestore:"topic,omitempty"`
+	DataType string `json:"data_type" firestore:"data_type"` // "entry", "response" or "digest"
+	// Category is the subscriber category of entry notification, or the entry category of response notification
+	Category   string `json:"category,omitempty" firestore:"category,omitempty"`
+	EntryID    string `json:"entry_id,omitempty" firestore:"entry_id,omitempty"`
+	ResponseID string `json:"response_id,omitempty" firestore:"response_id,omitempty"`
+	Tokens     int    `json:"tokens" firestore:"tokens"`
+	Sent       int    `json:"sent" firestore:"sent"`
+	Pruned     int    `json:"pruned" firestore:"pruned"`
+	Failed     int    `json:"failed" firestore:"failed"`
+	// Errors is number of tokens by failure reason, eg. "unregistered" or "unavailable"
+	Errors    map[string]int `json:"errors,omitempty" firestore:"errors,omitempty"`
+	CreatedAt time.Time      `json:"created_at" firestore:"created_at"`
+}
+
+// DeliveryStats is the daily counters of push notification sends of a notification type and category
+type DeliveryStats struct {
+	Day           string `json:"day" firestore:"day"` // "2006-01-02" in server timezone
+	DataType      string `json:"data_type" firestore:"data_type"`
+	Category      string `json:"category" firestore:"category"`
+	Notifications int    `json:"notifications" firestore:"notifications"`
+	Tokens        int    `json:"tokens" firestore:"tokens"`
+	Sent          int    `json:"sent" firestore:"sent"`
+	Pruned        int    `json:"pruned" firestore:"pruned"`
+	Failed        int    `json:"failed" firestore:"failed"`
 }
@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // ServicePort ...
 var ServicePort = os.Getenv("SERVICE_PORT")
@@ -14,17 +19,157 @@ var MinifluxUser = os.Getenv("MINIFLUX_USER")
 // MinifluxPass ...
 var MinifluxPass = os.Getenv("MINIFLUX_PASS")
 
+// MinifluxToken is Miniflux API key, used instead of user/pass when set
+var MinifluxToken = os.Getenv("MINIFLUX_TOKEN")
+
+// MinifluxMaxRetries is number of retries for failed Miniflux request
+var MinifluxMaxRetries = intEnv("MINIFLUX_MAX_RETRIES", 3)
+
+// MinifluxRetryDelay is the initial retry backoff delay
+var MinifluxRetryDelay = durationEnv("MINIFLUX_RETRY_DELAY", 500*time.Millisecond)
+
+// MinifluxBreakerThreshold is number of consecutive failures before we stop calling Miniflux
+var MinifluxBreakerThreshold = intEnv("MINIFLUX_BREAKER_THRESHOLD", 5)
+
+// MinifluxBreakerCooldown is how long we stop calling Miniflux after too many failures
+var MinifluxBreakerCooldown = durationEnv("MINIFLUX_BREAKER_COOLDOWN", 30*time.Second)
+
 // GCPProject ...
 var GCPProject = os.Getenv("GCP_PROJECT")
 
 // PushNotificationTopic ...
 var PushNotificationTopic = os.Getenv("PUSH_NOTIFICATION_TOPIC")
 
+// SyncTopic is the topic of Miniflux sync messages, only used by "local" transport
+var SyncTopic = os.Getenv("SYNC_TOPIC")
+
+// FirestoreEventsTopic is the topic of Firestore events published by Firesub
+var FirestoreEventsTopic = os.Getenv("FIRESTORE_EVENTS_TOPIC")
+
+// Transport is the message transport: "pubsub" (Google Pub/Sub) or "local" (in-process).
+// Default to "local" on "memory" store, otherwise "pubsub".
+var Transport = os.Getenv("TRANSPORT")
+
 // PubSubAPIKey ...
 var PubSubAPIKey = os.Getenv("PUBSUB_API_KEY")
 
+// AdminAPIKey protects /admin/** APIs, admin APIs are disabled when not set
+var AdminAPIKey = os.Getenv("ADMIN_API_KEY")
+
+// ReconcileWindow is how far back entries are reconciled when not specified
+var ReconcileWindow = durationEnv("RECONCILE_WINDOW", 24*time.Hour)
+
+// ReconcileRate is max Miniflux calls and store writes per second during reconciliation
+var ReconcileRate = intEnv("RECONCILE_RATE", 10)
+
+// RoutesFile is JSON file of the entry collections routing table
+var RoutesFile = os.Getenv("ROUTES_FILE")
+
+// RoutesDoc is Firestore document of the entry collections routing table, eg. "config/routes".
+// When neither RoutesFile nor RoutesDoc is set, the built-in BaliFeed table is used.
+var RoutesDoc = os.Getenv("ROUTES_DOC")
+
+// DedupTTL is how long processed Pub/Sub message IDs are remembered,
+// Pub/Sub redelivers unacked messages for up to 7 days.
+var DedupTTL = durationEnv("DEDUP_TTL", 7*24*time.Hour)
+
+// MaxDeliveryAttempts is number of attempts before failed Pub/Sub message is parked in dead letters
+var MaxDeliveryAttempts = intEnv("MAX_DELIVERY_ATTEMPTS", 5)
+
+// TopicCategories are the subscriber categories (comma separated, eg. "13,balebengong") notified
+// by single FCM topic message instead of a message per subscriber.
+var TopicCategories = listEnv("TOPIC_CATEGORIES")
+
+// Timezone is the default timezone of users quiet hours, default to Bali (Asia/Makassar)
+var Timezone = os.Getenv("TIMEZONE")
+
+// PushMaxRetries is number of retries for FCM tokens failed with quota or unavailable errors
+var PushMaxRetries = intEnv("PUSH_MAX_RETRIES", 2)
+
+// PushRetryDelay is the initial FCM retry backoff delay
+var PushRetryDelay = durationEnv("PUSH_RETRY_DELAY", time.Second)
+
+// WorkerSubscriptions are the Pub/Sub subscriptions pulled by `server worker`,
+// comma separated handler=subscription, eg. "push-notification=PushNotificationWorker".
+// Handlers are named after the push endpoints: sync-data, reconcile, push-notification and firestore-events.
+var WorkerSubscriptions = os.Getenv("WORKER_SUBSCRIPTIONS")
+
+// WorkerConcurrency is max messages handled at the same time per subscription
+var WorkerConcurrency = intEnv("WORKER_CONCURRENCY", 10)
+
+// WorkerMaxOutstandingBytes is max size of unacked messages per subscription, 0 means Pub/Sub default
+var WorkerMaxOutstandingBytes = intEnv("WORKER_MAX_OUTSTANDING_BYTES", 0)
+
+// ListenerWindow is how far back (by published_at) entries are watched by `server listen`,
+// older entries are not expected to be created.
+var ListenerWindow = durationEnv("LISTENER_WINDOW", 24*time.Hour)
+
+// Reactions are the allowed entry reactions (comma separated), default to "HAPPY,SURPRISE,SAD,ANGRY"
+var Reactions = listEnv("REACTIONS")
+
+// CommentMaxLength is max characters of a comment written through the API
+var CommentMaxLength = intEnv("COMMENT_MAX_LENGTH", 1000)
+
+// Store is the storage backend: "firestore" (default), "memory", "sqlite" or "postgres"
+var Store = os.Getenv("STORE")
+
+// DatabaseURL is the SQL database DSN, only used by "sqlite" and "postgres" store.
+// Note that SQLite driver requires CGO.
+var DatabaseURL = os.Getenv("DATABASE_URL")
+
 func init() {
 	if ServicePort == "" {
 		ServicePort = "8080"
 	}
+	if Store == "" {
+		Store = "firestore"
+	}
+	if Timezone == "" {
+		Timezone = "Asia/Makassar"
+	}
+	if Transport == "" {
+		Transport = "pubsub"
+		if Store == "memory" {
+			Transport = "local"
+		}
+	}
+	if PushNotificationTopic == "" {
+		PushNotificationTopic = "PushNotification"
+	}
+	if SyncTopic == "" {
+		SyncTopic = "SyncData"
+	}
+	if FirestoreEventsTopic == "" {
+		FirestoreEventsTopic = "FirestoreEvents"
+	}
+	if len(Reactions) == 0 {
+		Reactions = []string{"HAPPY", "SURPRISE", "SAD", "ANGRY"}
+	}
+}
+
+// intEnv returns env value as int, or def when not set or invalid
+func intEnv(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// listEnv returns comma separated env value as list, empty items are skipped
+func listEnv(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// durationEnv returns env value (eg. "30s") as time.Duration, or def when not set or invalid
+func durationEnv(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
 }
@@ -10,7 +10,14 @@ require (
 	github.com/fiberweb/pubsub v1.1.0
 	github.com/gofiber/fiber v1.8.42
 	github.com/klauspost/compress v1.10.3 // indirect
+	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.13.0
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	google.golang.org/api v0.14.0
+	google.golang.org/grpc v1.21.1
 )
+
+// fiber v1.8.32 (required by fiberweb middlewares) requires github.com/gofiber/template v1.0.0
+// which is no longer available, fiber doesn't import it so any version satisfies the module graph.
+replace github.com/gofiber/template v1.0.0 => github.com/gofiber/template v1.8.3
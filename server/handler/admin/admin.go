@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/fiberweb/apikey"
+	"github.com/gofiber/fiber"
+
+	"server/common/store"
+	"server/common/transport"
+)
+
+// Handler represents the handler for admin APIs
+type Handler struct {
+	store      store.Store
+	processors map[string]transport.Handler // PubSub handler path -> its message handler
+}
+
+// New returns Handler instance, processors maps PubSub handler path (eg. "/pubsub/sync-data")
+// to its message handler, used to replay dead letters in-process.
+func New(s store.Store, processors map[string]transport.Handler) *Handler {
+	return &Handler{store: s, processors: processors}
+}
+
+// Routes is collection handler for admin APIs, protected by key
+func (h *Handler) Routes(app *fiber.Fiber, pathPrefix, key string) {
+
+	admin := app.Group(pathPrefix)
+	admin.Use(apikey.New(apikey.Config{Key: key}))
+
+	admin.Get("/dead-letters", h.handleDeadLetters())
+	admin.Delete("/dead-letters", h.handlePurgeDeadLetters())
+	admin.Get("/dead-letters/:id", h.handleDeadLetter())
+	admin.Delete("/dead-letters/:id", h.handleDeleteDeadLetter())
+	admin.Post("/dead-letters/:id/replay", h.handleReplayDeadLetter())
+
+	admin.Get("/deliveries", h.handleDeliveries())
+	admin.Get("/deliveries/stats", h.handleDeliveryStats())
+
+	// server error handler
+	admin.Use(func(c *fiber.Ctx) {
+		if c.Error() != nil {
+			log.Println("[ERROR]", c.Error())
+			c.SendStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Next()
+	})
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber"
+
+	"server/common/store"
+	"server/config"
+)
+
+// statsDays is the default number of days reported by delivery stats
+const statsDays = 7
+
+// deliverySummary is the sum of delivery counters, Rate is Sent / Tokens
+type deliverySummary struct {
+	Notifications int     `json:"notifications"`
+	Tokens        int     `json:"tokens"`
+	Sent          int     `json:"sent"`
+	Pruned        int     `json:"pruned"`
+	Failed        int     `json:"failed"`
+	Rate          float64 `json:"rate"`
+}
+
+func (s *deliverySummary) add(notifications, tokens, sent, pruned, failed int) {
+	s.Notifications += notifications
+	s.Tokens += tokens
+	s.Sent += sent
+	s.Pruned += pruned
+	s.Failed += failed
+	if s.Tokens > 0 {
+		s.Rate = float64(s.Sent) / float64(s.Tokens)
+	}
+}
+
+// handleDeliveries returns delivery records filtered by ?entry_id=, ?response_id= or ?user_id=, with their sum
+func (h *Handler) handleDeliveries() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		lim, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || lim <= 0 {
+			lim = defaultLimit
+		}
+		if lim > maxLimit {
+			lim = maxLimit
+		}
+
+		deliveries, err := h.store.ListDeliveries(context.Background(), store.DeliveryQuery{
+			UserID:     c.Query("user_id"),
+			EntryID:    c.Query("entry_id"),
+			ResponseID: c.Query("response_id"),
+			Limit:      lim,
+		})
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		total := &deliverySummary{}
+		reasons := make(map[string]int)
+		for _, d := range deliveries {
+			total.add(1, d.Tokens, d.Sent, d.Pruned, d.Failed)
+			for reason, n := range d.Errors {
+				reasons[reason] += n
+			}
+		}
+		c.JSON(map[string]interface{}{
+			"deliveries": deliveries,
+			"total":      total,
+			"errors":     reasons,
+		})
+	}
+}
+
+// handleDeliveryStats reports delivery rate per notification type and per category
+// from ?from= to ?to= ("2006-01-02"), default to the last 7 days
+func (h *Handler) handleDeliveryStats() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			location = time.UTC
+		}
+		today := time.Now().In(location)
+		from := c.Query("from")
+		if from == "" {
+			from = today.AddDate(0, 0, 1-statsDays).Format("2006-01-02")
+		}
+		to := c.Query("to")
+		if to == "" {
+			to = today.Format("2006-01-02")
+		}
+		for _, day := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", day); err != nil {
+				c.Status(http.StatusBadRequest).JSON(map[string]string{"error": "from and to must be formatted as 2006-01-02"})
+				return
+			}
+		}
+
+		days, err := h.store.ListDeliveryStats(context.Background(), from, to)
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		total := &deliverySummary{}
+		byType := make(map[string]*deliverySummary)
+		byCategory := make(map[string]*deliverySummary)
+		for _, d := range days {
+			if byType[d.DataType] == nil {
+				byType[d.DataType] = &deliverySummary{}
+			}
+			if byCategory[d.Category] == nil {
+				byCategory[d.Category] = &deliverySummary{}
+			}
+			for _, s := range []*deliverySummary{total, byType[d.DataType], byCategory[d.Category]} {
+				s.add(d.Notifications, d.Tokens, d.Sent, d.Pruned, d.Failed)
+			}
+		}
+		c.JSON(map[string]interface{}{
+			"from":        from,
+			"to":          to,
+			"total":       total,
+			"by_type":     byType,
+			"by_category": byCategory,
+			"days":        days,
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber"
+
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+func (h *Handler) handleDeadLetters() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		lim, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || lim <= 0 {
+			lim = defaultLimit
+		}
+		if lim > maxLimit {
+			lim = maxLimit
+		}
+
+		q := store.DeadLetterQuery{Path: c.Query("path"), Limit: lim}
+		messages, err := h.store.ListDeadLetters(context.Background(), q)
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		c.JSON(messages)
+	}
+}
+
+func (h *Handler) handleDeadLetter() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		msg, err := h.store.GetDeadLetter(context.Background(), c.Params("id"))
+		if err == store.ErrNotFound {
+			c.SendStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		c.JSON(msg)
+	}
+}
+
+func (h *Handler) handleDeleteDeadLetter() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		if err := h.store.DeleteDeadLetter(context.Background(), c.Params("id")); err != nil {
+			c.Next(err)
+			return
+		}
+		c.SendStatus(http.StatusNoContent)
+	}
+}
+
+// handlePurgeDeadLetters deletes messages parked before ?before=<unix timestamp>, default to all
+func (h *Handler) handlePurgeDeadLetters() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		before := time.Now()
+		if v := c.Query("before"); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.Status(http.StatusBadRequest).JSON(map[string]string{"error": "before must be unix timestamp"})
+				return
+			}
+			before = time.Unix(ts, 0)
+		}
+
+		deleted, err := h.store.PurgeDeadLetters(context.Background(), before)
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		c.JSON(map[string]int{"deleted": deleted})
+	}
+}
+
+// handleReplayDeadLetter re-runs the original handler in-process,
+// the message is deleted when succeed, otherwise its error and attempts are updated.
+func (h *Handler) handleReplayDeadLetter() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		ctx := context.Background()
+
+		dl, err := h.store.GetDeadLetter(ctx, c.Params("id"))
+		if err == store.ErrNotFound {
+			c.SendStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		process, ok := h.processors[dl.Path]
+		if !ok {
+			c.Status(http.StatusUnprocessableEntity).JSON(map[string]string{
+				"error": fmt.Sprintf("no handler for %s", dl.Path),
+			})
+			return
+		}
+
+		if err := process(ctx, toMessage(dl)); err != nil {
+			dl.Attempts++
+			dl.Error = err.Error()
+			if serr := h.store.SaveDeadLetter(ctx, dl); serr != nil {
+				c.Next(serr)
+				return
+			}
+			c.Status(http.StatusUnprocessableEntity).JSON(map[string]interface{}{
+				"error":    dl.Error,
+				"attempts": dl.Attempts,
+			})
+			return
+		}
+
+		if err := h.store.DeleteDeadLetter(ctx, dl.ID); err != nil {
+			c.Next(err)
+			return
+		}
+		c.JSON(map[string]string{"replayed": dl.ID})
+	}
+}
+
+// toMessage converts dead letter back into the original message
+func toMessage(dl *types.DeadLetter) *transport.Message {
+	return &transport.Message{ID: dl.ID, Data: dl.Data, Attributes: dl.Attributes, DeliveryAttempt: dl.Attempts + 1}
+}
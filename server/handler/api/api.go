@@ -6,37 +6,63 @@ import (
 
 	"github.com/gofiber/fiber"
 
+	"server/common/auth"
 	"server/common/constant"
+	"server/common/routing"
 	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
 )
 
 // Handler represents the handler for APIs
 type Handler struct {
-	google *service.Google
+	// Events publishes entry response changes to events handler, for stores without Firestore change events.
+	// Nil when the responses are aggregated from Firestore.
+	Events transport.Publisher
+
+	store    store.Store
+	routes   *routing.Table
+	verifier service.Verifier
 }
 
-// New returns Handler instance
-func New(google *service.Google) *Handler {
-	return &Handler{google}
+// New returns Handler instance, v verifies Firebase ID token of authenticated requests
+func New(s store.Store, routes *routing.Table, v service.Verifier) *Handler {
+	return &Handler{store: s, routes: routes, verifier: v}
 }
 
 // Routes is collection handler for API
 func (h *Handler) Routes(app *fiber.Fiber, pathPrefix string) {
 
 	api := app.Group(pathPrefix)
+	api.Use(auth.Optional(h.verifier)) // uid is available to every handler when the request has ID token
 	api.Get("/feeds", h.handleFeeds())
 
-	api.Get("/entries", h.handleEntries(constant.Entries))
-	api.Get("/entries/:entryId", h.handleEntry(constant.Entries))
+	api.Get("/collections", h.handleCollections())
+
+	// routes are generated from the collection registry
+	for _, collection := range h.routes.Collections() {
+		path := entriesPath(collection)
+		api.Get(path, h.handleEntries(collection))
+		api.Get(path+"/:entryId", h.handleEntry(collection))
+	}
 
-	api.Get("/kriminal/entries", h.handleEntries(constant.Kriminal))
-	api.Get("/kriminal/entries/:entryId", h.handleEntry(constant.Kriminal))
+	// threaded comments of an entry
+	api.Get("/entries/:collection/:id/comments", h.handleComments())
+	api.Get("/comments/:threadId/replies", h.handleReplies())
 
-	api.Get("/baliunited/entries", h.handleEntries(constant.BaliUnited))
-	api.Get("/baliunited/entries/:entryId", h.handleEntry(constant.BaliUnited))
+	required, self := auth.Required(h.verifier), h.requireSelf()
 
-	api.Get("/balebengong/entries", h.handleEntries(constant.BaleBengong))
-	api.Get("/balebengong/entries/:entryId", h.handleEntry(constant.BaleBengong))
+	// entry responses written by the authenticated user
+	api.Post("/entries/:collection/:id/responses", required, h.handleCreateResponse())
+	api.Patch("/entries/:collection/:id/responses/:responseId", required, h.handleUpdateResponse())
+	api.Delete("/entries/:collection/:id/responses/:responseId", required, h.handleDeleteResponse())
+
+	// user's notifications inbox, only accessible by the user
+	api.Get("/users/:userId/notifications", required, self, h.handleNotifications())
+	api.Get("/users/:userId/notifications/unread", required, self, h.handleUnreadCount())
+	api.Post("/users/:userId/notifications/read", required, self, h.handleMarkRead())
+	api.Delete("/users/:userId/notifications", required, self, h.handleClearNotifications())
+	api.Delete("/users/:userId/notifications/:id", required, self, h.handleClearNotifications())
 
 	// server error handler
 	api.Use(func(c *fiber.Ctx) {
@@ -48,3 +74,33 @@ func (h *Handler) Routes(app *fiber.Fiber, pathPrefix string) {
 		c.Next()
 	})
 }
+
+// requireSelf rejects request of authenticated user to other user's resources (:userId)
+func (h *Handler) requireSelf() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		if auth.UID(c) != c.Params("userId") {
+			c.SendStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// hasCollection reports whether collection is a registered entries collection
+func (h *Handler) hasCollection(collection string) bool {
+	for _, c := range h.routes.Collections() {
+		if c == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// entriesPath returns the API path of a collection entries,
+// default collection is served at /entries, the others at /<collection>/entries.
+func entriesPath(collection string) string {
+	if collection == constant.Entries {
+		return "/entries"
+	}
+	return "/" + collection + "/entries"
+}
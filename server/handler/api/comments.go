@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber"
+
+	"server/common/store"
+	"server/common/types"
+)
+
+const (
+	commentsMaxAge  = 60  // browser cache: 1 min
+	commentsSmaxAge = 300 // CDN cache: 5 mins
+	maxReplies      = 10  // max replies expanded per comment
+)
+
+// comment is the API representation of a comment, without the entry snapshot
+type comment struct {
+	ID         string             `json:"id"`
+	UserID     string             `json:"user_id"`
+	EntryID    int64              `json:"entry_id"`
+	ParentID   string             `json:"parent_id"`
+	ThreadID   string             `json:"thread_id"`
+	Comment    string             `json:"comment"`
+	User       types.ResponseUser `json:"user"`
+	ReplyCount int64              `json:"reply_count"`
+	CreatedAt  int64              `json:"created_at"`
+	Replies    []*comment         `json:"replies,omitempty"`
+}
+
+func newComment(r *types.Response) *comment {
+	return &comment{
+		ID:         r.ID,
+		UserID:     r.UserID,
+		EntryID:    r.EntryID,
+		ParentID:   r.ParentID,
+		ThreadID:   r.ThreadID,
+		Comment:    r.Comment,
+		User:       r.User,
+		ReplyCount: r.ReplyCount,
+		CreatedAt:  r.CreatedAt,
+	}
+}
+
+// handleComments returns top level comments of the entry, newest first.
+// Query params: limit (default 10, max 20), cursor and cursor_id (created_at and id of the last comment)
+// and replies=N to expand the first N (max 10) replies of every comment.
+func (h *Handler) handleComments() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		ctx := context.Background()
+		entry, ok := h.responseEntry(c)
+		if !ok {
+			return
+		}
+
+		lim, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || lim <= 0 {
+			lim = 10
+		} else if lim > 20 {
+			lim = 20
+		}
+
+		cur, err := strconv.ParseInt(c.Query("cursor"), 10, 64)
+		if err != nil {
+			cur = 0
+		}
+
+		replies, err := strconv.Atoi(c.Query("replies"))
+		if err != nil || replies < 0 {
+			replies = 0
+		} else if replies > maxReplies {
+			replies = maxReplies
+		}
+
+		responses, err := h.store.ListComments(ctx, store.CommentQuery{
+			EntryID:  entry.ID,
+			Cursor:   cur,
+			CursorID: c.Query("cursor_id"),
+			Limit:    lim,
+		})
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		comments := make([]*comment, 0, len(responses))
+		for i := range responses {
+			cm := newComment(&responses[i])
+			if replies > 0 && cm.ReplyCount > 0 {
+				if cm.Replies, err = h.threadReplies(ctx, cm.ID, replies); err != nil {
+					c.Next(err)
+					return
+				}
+			}
+			comments = append(comments, cm)
+		}
+
+		if len(comments) > 0 {
+			h.setCacheControl(c, commentsMaxAge, commentsSmaxAge)
+		}
+		h.sendJSON(c, comments)
+	}
+}
+
+// handleReplies returns the top level comment with all replies of its thread, oldest first
+func (h *Handler) handleReplies() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		ctx := context.Background()
+		r, err := h.store.GetResponse(ctx, c.Params("threadId"))
+		if err == store.ErrNotFound || (err == nil && (r.Type != typeComment || r.ThreadID != "")) {
+			c.SendStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		thread := newComment(r)
+		if thread.Replies, err = h.threadReplies(ctx, r.ID, 0); err != nil {
+			c.Next(err)
+			return
+		}
+		h.setCacheControl(c, commentsMaxAge, commentsSmaxAge)
+		h.sendJSON(c, thread)
+	}
+}
+
+// threadReplies returns the first limit (0 means all) replies in the thread (including replies of replies), oldest first
+func (h *Handler) threadReplies(ctx context.Context, threadID string, limit int) ([]*comment, error) {
+	responses, err := h.store.ListReplies(ctx, store.ReplyQuery{ThreadID: threadID, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]*comment, 0, len(responses))
+	for i := range responses {
+		replies = append(replies, newComment(&responses[i]))
+	}
+	return replies, nil
+}
@@ -40,6 +40,19 @@ func (h *Handler) handleFeeds() func(*fiber.Ctx) {
 	}
 }
 
+func (h *Handler) handleCollections() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		collections, err := h.getCollections(context.Background())
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		h.setCacheControl(c, defaultMaxAge, defaultSmaxAge)
+		h.sendJSON(c, collections)
+	}
+}
+
 func (h *Handler) handleEntries(collection string) func(*fiber.Ctx) {
 	return func(c *fiber.Ctx) {
 		cat, err := strconv.Atoi(c.Query("categoryId"))
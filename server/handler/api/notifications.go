@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber"
+
+	"server/common/store"
+)
+
+// setNoCache disables caching of user's private responses
+func (h *Handler) setNoCache(c *fiber.Ctx) {
+	c.Set("Cache-Control", "private, no-store")
+}
+
+// handleNotifications returns user's inbox notifications, newest first.
+// Query params: limit (default 20, max 50), cursor and cursor_id (next_cursor and next_cursor_id of previous page)
+// and unread=1 for unread only.
+func (h *Handler) handleNotifications() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		lim, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || lim <= 0 {
+			lim = 20
+		} else if lim > 50 {
+			lim = 50
+		}
+
+		cur, err := strconv.ParseInt(c.Query("cursor"), 10, 64)
+		if err != nil {
+			cur = 0
+		}
+
+		items, err := h.store.ListNotifications(context.Background(), store.NotificationQuery{
+			UserID:     c.Params("userId"),
+			Cursor:     cur,
+			CursorID:   c.Query("cursor_id"),
+			UnreadOnly: c.Query("unread") == "1",
+			Limit:      lim,
+		})
+		if err != nil {
+			c.Next(err)
+			return
+		}
+
+		// created_at (unix millisecs) and ID of the last item, empty when there is no more page
+		var next int64
+		var nextID string
+		if len(items) == lim {
+			next = items[len(items)-1].CreatedAt.UnixNano() / int64(time.Millisecond)
+			nextID = items[len(items)-1].ID
+		}
+		h.setNoCache(c)
+		h.sendJSON(c, map[string]interface{}{
+			"notifications":  items,
+			"next_cursor":    next,
+			"next_cursor_id": nextID,
+		})
+	}
+}
+
+// handleUnreadCount returns number of unread notifications in user's inbox
+func (h *Handler) handleUnreadCount() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		count, err := h.store.CountUnreadNotifications(context.Background(), c.Params("userId"))
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		h.setNoCache(c)
+		h.sendJSON(c, map[string]int{"unread": count})
+	}
+}
+
+// notificationIDs is the request body of notifications updates, empty ids means all notifications
+type notificationIDs struct {
+	IDs []string `json:"ids"`
+}
+
+// handleMarkRead marks notifications in request body as read, or all when the body is empty
+func (h *Handler) handleMarkRead() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		var body notificationIDs
+		if c.Body() != "" {
+			if err := c.BodyParser(&body); err != nil {
+				c.Status(http.StatusBadRequest).JSON(map[string]string{"error": "body must be {\"ids\": [...]}"})
+				return
+			}
+		}
+		if err := h.store.MarkNotificationsRead(context.Background(), c.Params("userId"), body.IDs); err != nil {
+			c.Next(err)
+			return
+		}
+		c.SendStatus(http.StatusNoContent)
+	}
+}
+
+// handleClearNotifications deletes single notification, or clears user's inbox
+func (h *Handler) handleClearNotifications() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		var ids []string
+		if id := c.Params("id"); id != "" {
+			ids = []string{id}
+		}
+		if err := h.store.DeleteNotifications(context.Background(), c.Params("userId"), ids); err != nil {
+			c.Next(err)
+			return
+		}
+		c.SendStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber"
+
+	"server/common/auth"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+	"server/config"
+)
+
+const (
+	typeComment  = "COMMENT"
+	typeReaction = "REACTION"
+)
+
+// responseBody is the request body of entry response writes
+type responseBody struct {
+	Type     string `json:"type"`
+	Reaction string `json:"reaction"`
+	Comment  string `json:"comment"`
+	ParentID string `json:"parent_id"`
+	ThreadID string `json:"thread_id"`
+}
+
+// badRequest responds with validation error
+func badRequest(c *fiber.Ctx, format string, args ...interface{}) {
+	c.Status(http.StatusBadRequest).JSON(map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// reactionConflict responds with 409 and the ID of user's existing reaction
+func reactionConflict(c *fiber.Ctx, id string) {
+	c.Status(http.StatusConflict).JSON(map[string]string{"error": "entry already has your reaction", "id": id})
+}
+
+// handleCreateResponse creates a comment or reaction of the entry by the authenticated user,
+// users can only have one reaction per entry.
+func (h *Handler) handleCreateResponse() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		ctx := context.Background()
+		entry, ok := h.responseEntry(c)
+		if !ok {
+			return
+		}
+		collection := c.Params("collection")
+
+		var body responseBody
+		if err := c.BodyParser(&body); err != nil {
+			badRequest(c, "invalid request body")
+			return
+		}
+
+		uid := auth.UID(c)
+		claims := auth.Claims(c)
+		name, _ := claims["name"].(string)
+		avatar, _ := claims["picture"].(string)
+		r := &types.Response{
+			UserID:          uid,
+			Type:            body.Type,
+			EntryID:         entry.ID,
+			EntryCategoryID: entry.CategoryID,
+			EntryFeedID:     entry.FeedID,
+			Entry:           *entry,
+			User:            types.ResponseUser{ID: uid, Name: name, Avatar: avatar},
+			CreatedAt:       time.Now().UnixNano() / int64(time.Millisecond),
+		}
+
+		switch body.Type {
+		case typeComment:
+			if msg := validateComment(body.Comment); msg != "" {
+				badRequest(c, msg)
+				return
+			}
+			if body.Reaction != "" {
+				badRequest(c, "comment can't have reaction")
+				return
+			}
+			r.Comment = strings.TrimSpace(body.Comment)
+			if body.ParentID == "" && body.ThreadID != "" {
+				badRequest(c, "thread_id requires parent_id")
+				return
+			}
+			if body.ParentID != "" {
+				parent, err := h.store.GetResponse(ctx, body.ParentID)
+				if err == store.ErrNotFound || (err == nil && (parent.Type != typeComment || !h.ofEntry(parent, collection, entry))) {
+					badRequest(c, "parent must be a comment of the same entry")
+					return
+				}
+				if err != nil {
+					c.Next(err)
+					return
+				}
+				// replies of replies belong to the thread of the top level comment
+				threadID := parent.ThreadID
+				if threadID == "" {
+					threadID = parent.ID
+				}
+				if body.ThreadID != "" && body.ThreadID != threadID {
+					badRequest(c, "thread_id must be %s", threadID)
+					return
+				}
+				r.ParentID, r.ThreadID = parent.ID, threadID
+			}
+			id, err := newID()
+			if err != nil {
+				c.Next(err)
+				return
+			}
+			r.ID = id
+
+		case typeReaction:
+			if msg := validateReaction(body.Reaction); msg != "" {
+				badRequest(c, msg)
+				return
+			}
+			if body.Comment != "" || body.ParentID != "" || body.ThreadID != "" {
+				badRequest(c, "reaction can't have comment, parent_id or thread_id")
+				return
+			}
+			// reactions written by the app directly don't have the per user ID below
+			existing, err := h.store.ListResponses(ctx, store.ResponseQuery{EntryID: entry.ID, UserID: uid, Type: typeReaction})
+			if err != nil {
+				c.Next(err)
+				return
+			}
+			for i := range existing {
+				if h.ofEntry(&existing[i], collection, entry) {
+					reactionConflict(c, existing[i].ID)
+					return
+				}
+			}
+			r.Reaction = body.Reaction
+			// one reaction document per user per entry, only one of concurrent requests can create it
+			r.ID = "reaction_" + collection + "_" + strconv.FormatInt(entry.ID, 10) + "_" + uid
+
+		default:
+			badRequest(c, "type must be %s or %s", typeComment, typeReaction)
+			return
+		}
+
+		err := h.store.CreateResponse(ctx, r)
+		if err == store.ErrAlreadyExists && r.Type == typeReaction {
+			reactionConflict(c, r.ID)
+			return
+		}
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		if err := h.publishResponse(ctx, r.ID, nil, r); err != nil {
+			c.Next(err)
+			return
+		}
+		c.Status(http.StatusCreated)
+		h.sendJSON(c, r)
+	}
+}
+
+// handleUpdateResponse updates comment text or reaction of the authenticated user's response
+func (h *Handler) handleUpdateResponse() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		ctx := context.Background()
+		before, ok := h.ownResponse(c)
+		if !ok {
+			return
+		}
+
+		var body responseBody
+		if err := c.BodyParser(&body); err != nil {
+			badRequest(c, "invalid request body")
+			return
+		}
+		if (body.Type != "" && body.Type != before.Type) ||
+			(body.ParentID != "" && body.ParentID != before.ParentID) ||
+			(body.ThreadID != "" && body.ThreadID != before.ThreadID) {
+			badRequest(c, "type, parent_id and thread_id can't be changed")
+			return
+		}
+
+		// only the edited field is written, counters may be incremented concurrently by events handler
+		after := *before
+		var fields map[string]interface{}
+		switch before.Type {
+		case typeComment:
+			if msg := validateComment(body.Comment); msg != "" {
+				badRequest(c, msg)
+				return
+			}
+			if body.Reaction != "" {
+				badRequest(c, "comment can't have reaction")
+				return
+			}
+			after.Comment = strings.TrimSpace(body.Comment)
+			fields = map[string]interface{}{"comment": after.Comment}
+		case typeReaction:
+			if msg := validateReaction(body.Reaction); msg != "" {
+				badRequest(c, msg)
+				return
+			}
+			if body.Comment != "" {
+				badRequest(c, "reaction can't have comment")
+				return
+			}
+			after.Reaction = body.Reaction
+			fields = map[string]interface{}{"reaction": after.Reaction}
+		}
+
+		err := h.store.UpdateResponse(ctx, after.ID, fields)
+		if err == store.ErrNotFound {
+			// deleted meanwhile
+			c.SendStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		if err := h.publishResponse(ctx, after.ID, before, &after); err != nil {
+			c.Next(err)
+			return
+		}
+		h.sendJSON(c, after)
+	}
+}
+
+// handleDeleteResponse deletes the authenticated user's response, replies of deleted comment are deleted by events handler
+func (h *Handler) handleDeleteResponse() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		ctx := context.Background()
+		before, ok := h.ownResponse(c)
+		if !ok {
+			return
+		}
+		if err := h.store.DeleteResponses(ctx, []string{before.ID}); err != nil {
+			c.Next(err)
+			return
+		}
+		if err := h.publishResponse(ctx, before.ID, before, nil); err != nil {
+			c.Next(err)
+			return
+		}
+		c.SendStatus(http.StatusNoContent)
+	}
+}
+
+// responseEntry returns the entry of :collection/:id, responds with 404 when not found
+func (h *Handler) responseEntry(c *fiber.Ctx) (*types.Entry, bool) {
+	collection := c.Params("collection")
+	if !h.hasCollection(collection) {
+		c.SendStatus(http.StatusNotFound)
+		return nil, false
+	}
+	doc, err := h.store.GetEntry(context.Background(), collection, c.Params("id"))
+	if err == store.ErrNotFound {
+		c.SendStatus(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		c.Next(err)
+		return nil, false
+	}
+
+	// entry documents have the same json and firestore field names
+	j, err := json.Marshal(doc)
+	if err != nil {
+		c.Next(err)
+		return nil, false
+	}
+	var entry types.Entry
+	if err := json.Unmarshal(j, &entry); err != nil {
+		c.Next(err)
+		return nil, false
+	}
+	return &entry, true
+}
+
+// ofEntry reports whether r is a response of the entry in collection,
+// entries of other collections may have the same ID (eg. kriminal and baliunited are keyed by PublishedAt).
+func (h *Handler) ofEntry(r *types.Response, collection string, entry *types.Entry) bool {
+	return r.EntryID == entry.ID && h.routes.Collection(r.EntryCategoryID, r.EntryFeedID) == collection
+}
+
+// ownResponse returns :responseId of the entry, responds with 404 when not found or 403 when not authored by the user
+func (h *Handler) ownResponse(c *fiber.Ctx) (*types.Response, bool) {
+	entry, ok := h.responseEntry(c)
+	if !ok {
+		return nil, false
+	}
+	r, err := h.store.GetResponse(context.Background(), c.Params("responseId"))
+	if err == store.ErrNotFound || (err == nil && !h.ofEntry(r, c.Params("collection"), entry)) {
+		c.SendStatus(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		c.Next(err)
+		return nil, false
+	}
+	if r.UserID != auth.UID(c) {
+		c.SendStatus(http.StatusForbidden)
+		return nil, false
+	}
+	return r, true
+}
+
+// validateComment returns the validation error message of comment text, empty when valid
+func validateComment(comment string) string {
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return "comment is required"
+	}
+	if utf8.RuneCountInString(comment) > config.CommentMaxLength {
+		return fmt.Sprintf("comment is longer than %d characters", config.CommentMaxLength)
+	}
+	return ""
+}
+
+// validateReaction returns the validation error message of reaction, empty when valid
+func validateReaction(reaction string) string {
+	for _, r := range config.Reactions {
+		if r == reaction {
+			return ""
+		}
+	}
+	return "reaction must be one of " + strings.Join(config.Reactions, ", ")
+}
+
+// publishResponse publishes the response change to events handler in Firesub format,
+// only when the store doesn't emit change events itself (Events is set).
+func (h *Handler) publishResponse(ctx context.Context, id string, before, after *types.Response) error {
+	if h.Events == nil {
+		return nil
+	}
+	j, err := json.Marshal(map[string]interface{}{
+		"id":        id,
+		"timestamp": time.Now().Format(time.RFC3339Nano),
+		"before":    before,
+		"after":     after,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.Events.Publish(ctx, config.FirestoreEventsTopic, &transport.Message{
+		Data:       j,
+		Attributes: map[string]string{"type": "responses"},
+	})
+	return err
+}
+
+// idChars are the characters of generated document IDs, the same as Firestore auto IDs
+const idChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// newID returns random 20 characters document ID
+func newID() (string, error) {
+	b := make([]byte, 20)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(idChars))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = idChars[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber"
+
+	"server/common/routing"
+	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+	"server/config"
+	"server/handler/events"
+)
+
+func TestValidateResponse(t *testing.T) {
+	for comment, valid := range map[string]bool{
+		"Mantap":                  true,
+		"   ":                     false,
+		strings.Repeat("a", 1000): true,
+		strings.Repeat("é", 1001): false,
+	} {
+		if msg := validateComment(comment); (msg == "") != valid {
+			t.Errorf("validateComment(%.10q) = %q, want valid=%v", comment, msg, valid)
+		}
+	}
+
+	if validateReaction("HAPPY") != "" || validateReaction("happy") == "" || validateReaction("") == "" {
+		t.Error("Only configured reactions should be valid")
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a, _ := newID()
+	b, _ := newID()
+	if len(a) != 20 || a == b {
+		t.Errorf("newID() = %q, %q, want unique 20 characters IDs", a, b)
+	}
+}
+
+// recorder records published messages
+type recorder struct {
+	topics []string
+	msgs   []*transport.Message
+}
+
+func (r *recorder) Publish(ctx context.Context, topic string, msg *transport.Message) (string, error) {
+	r.topics = append(r.topics, topic)
+	r.msgs = append(r.msgs, msg)
+	return "", nil
+}
+
+// responsesTest is the API with entries "1" and "2" in memory store,
+// published response changes are aggregated by events handler on flush.
+type responsesTest struct {
+	t      *testing.T
+	app    *fiber.Fiber
+	db     *store.Memory
+	events *recorder
+	agg    *events.Handler
+}
+
+func newResponsesTest(t *testing.T) *responsesTest {
+	ctx := context.Background()
+	db := store.NewMemory()
+	for _, id := range []int64{1, 2} {
+		db.SaveEntry(ctx, "entries", strconv.FormatInt(id, 10), &types.Entry{ID: id, CategoryID: 1, FeedID: 1, Title: "Berita"})
+	}
+	routes := routing.Default()
+	h := New(db, routes, service.NewLocal())
+	rt := &responsesTest{t: t, app: fiber.New(&fiber.Settings{CaseSensitive: true}), db: db, events: &recorder{}}
+	h.Events = rt.events
+	h.Routes(rt.app, "/api")
+	rt.agg = events.New(db, &recorder{}, service.NewLocal(), routes)
+	return rt
+}
+
+// do sends request as user uid (anonymous when empty) and decodes the JSON response into v
+func (rt *responsesTest) do(method, path, uid, body string, v interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	// the request is dumped into raw HTTP, body length isn't written otherwise
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if uid != "" {
+		// local verifier accepts user ID as token
+		req.Header.Set("Authorization", "Bearer "+uid)
+	}
+	res, err := rt.app.Test(req)
+	if err != nil {
+		rt.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if v != nil {
+		json.NewDecoder(res.Body).Decode(v)
+	}
+	return res.StatusCode
+}
+
+// flush aggregates published response changes by events handler
+func (rt *responsesTest) flush() {
+	for i, msg := range rt.events.msgs {
+		if rt.events.topics[i] != config.FirestoreEventsTopic || msg.Attributes["type"] != "responses" {
+			rt.t.Errorf("Unexpected message to %s: %v", rt.events.topics[i], msg.Attributes)
+		}
+		msg.ID = "event-" + strconv.Itoa(i)
+		if err := rt.agg.Process(context.Background(), msg); err != nil {
+			rt.t.Fatal(err)
+		}
+	}
+	rt.events.msgs, rt.events.topics = nil, nil
+}
+
+// count returns counter of entry "1"
+func (rt *responsesTest) count(name string) int64 {
+	entry, err := rt.db.GetEntry(context.Background(), "entries", "1")
+	if err != nil {
+		rt.t.Fatal(err)
+	}
+	n, _ := entry[name].(int64)
+	return n
+}
+
+func TestCreateResponse(t *testing.T) {
+	rt := newResponsesTest(t)
+	path := "/api/entries/entries/1/responses"
+
+	if status := rt.do(http.MethodPost, path, "", `{"type":"COMMENT","comment":"Mantap"}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("anonymous POST = %d, want 401", status)
+	}
+
+	var comment, reply types.Response
+	if status := rt.do(http.MethodPost, path, "u1", `{"type":"COMMENT","comment":"Mantap"}`, &comment); status != http.StatusCreated {
+		t.Fatalf("POST comment = %d, want 201", status)
+	}
+	if comment.UserID != "u1" || comment.EntryID != 1 || comment.CreatedAt == 0 {
+		t.Errorf("Unexpected comment %+v", comment)
+	}
+	body := `{"type":"COMMENT","comment":"Setuju","parent_id":"` + comment.ID + `"}`
+	if status := rt.do(http.MethodPost, path, "u2", body, &reply); status != http.StatusCreated {
+		t.Fatalf("POST reply = %d, want 201", status)
+	}
+	if reply.ParentID != comment.ID || reply.ThreadID != comment.ID {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+
+	// parent must exist and be on the same entry
+	for p, body := range map[string]string{
+		path:                               `{"type":"COMMENT","comment":"Setuju","parent_id":"missing"}`,
+		"/api/entries/entries/2/responses": `{"type":"COMMENT","comment":"Setuju","parent_id":"` + comment.ID + `"}`,
+	} {
+		if status := rt.do(http.MethodPost, p, "u2", body, nil); status != http.StatusBadRequest {
+			t.Errorf("POST %s %s = %d, want 400", p, body, status)
+		}
+	}
+
+	// one reaction per user
+	var existing map[string]string
+	if status := rt.do(http.MethodPost, path, "u1", `{"type":"REACTION","reaction":"HAPPY"}`, nil); status != http.StatusCreated {
+		t.Fatalf("POST reaction = %d, want 201", status)
+	}
+	if status := rt.do(http.MethodPost, path, "u1", `{"type":"REACTION","reaction":"SAD"}`, &existing); status != http.StatusConflict || existing["id"] == "" {
+		t.Errorf("POST second reaction = %d %v, want 409 with existing ID", status, existing)
+	}
+
+	// comment, reply and reaction are published once each
+	if len(rt.events.msgs) != 3 {
+		t.Fatalf("Published %d events, want 3", len(rt.events.msgs))
+	}
+	rt.flush()
+	if rt.count("comment_count") != 2 || rt.count("reaction_happy_count") != 1 || rt.count("reaction_sad_count") != 0 {
+		t.Error("Unexpected entry counters")
+	}
+	if r, _ := rt.db.GetResponse(context.Background(), comment.ID); r.ReplyCount != 1 {
+		t.Errorf("reply_count = %d, want 1", r.ReplyCount)
+	}
+}
+
+func TestReactionCollections(t *testing.T) {
+	rt := newResponsesTest(t)
+	ctx := context.Background()
+	// kriminal and baliunited entries are keyed by PublishedAt, their IDs may be the same
+	rt.db.SaveEntry(ctx, "kriminal", "5000", &types.Entry{ID: 5000, CategoryID: 11, Title: "Kriminal"})
+	rt.db.SaveEntry(ctx, "baliunited", "5000", &types.Entry{ID: 5000, CategoryID: 12, Title: "Bali United"})
+
+	var kriminal, baliunited types.Response
+	body := `{"type":"REACTION","reaction":"HAPPY"}`
+	if status := rt.do(http.MethodPost, "/api/entries/kriminal/5000/responses", "u1", body, &kriminal); status != http.StatusCreated {
+		t.Fatalf("POST kriminal reaction = %d, want 201", status)
+	}
+	if status := rt.do(http.MethodPost, "/api/entries/baliunited/5000/responses", "u1", body, &baliunited); status != http.StatusCreated {
+		t.Fatalf("POST baliunited reaction = %d, want 201", status)
+	}
+	if kriminal.ID == baliunited.ID {
+		t.Errorf("Reactions of different collections have the same ID %s", kriminal.ID)
+	}
+
+	// the response of kriminal entry isn't reachable from baliunited entry
+	path := "/api/entries/baliunited/5000/responses/" + kriminal.ID
+	if status := rt.do(http.MethodDelete, path, "u1", "", nil); status != http.StatusNotFound {
+		t.Errorf("DELETE %s = %d, want 404", path, status)
+	}
+}
+
+func TestUpdateResponse(t *testing.T) {
+	ctx := context.Background()
+	rt := newResponsesTest(t)
+
+	var comment types.Response
+	rt.do(http.MethodPost, "/api/entries/entries/1/responses", "u1", `{"type":"COMMENT","comment":"Mantap"}`, &comment)
+	path := "/api/entries/entries/1/responses/" + comment.ID
+
+	if status := rt.do(http.MethodPatch, path, "", `{"comment":"Diubah"}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("anonymous PATCH = %d, want 401", status)
+	}
+	if status := rt.do(http.MethodPatch, path, "u2", `{"comment":"Diubah"}`, nil); status != http.StatusForbidden {
+		t.Errorf("PATCH by other user = %d, want 403", status)
+	}
+
+	// reply counted meanwhile is kept
+	rt.db.RunTransaction(ctx, func(ctx context.Context, tx store.Tx) error {
+		return tx.IncrementResponse(comment.ID, map[string]int{"reply_count": 1})
+	})
+	if status := rt.do(http.MethodPatch, path, "u1", `{"comment":"Diubah"}`, nil); status != http.StatusOK {
+		t.Fatalf("PATCH = %d, want 200", status)
+	}
+	r, _ := rt.db.GetResponse(ctx, comment.ID)
+	if r.Comment != "Diubah" || r.ReplyCount != 1 {
+		t.Errorf("Unexpected updated comment %+v", r)
+	}
+	if len(rt.events.msgs) != 2 {
+		t.Errorf("Published %d events, want 2", len(rt.events.msgs))
+	}
+}
+
+func TestDeleteResponse(t *testing.T) {
+	ctx := context.Background()
+	rt := newResponsesTest(t)
+	path := "/api/entries/entries/1/responses"
+
+	var comment, reply types.Response
+	rt.do(http.MethodPost, path, "u1", `{"type":"COMMENT","comment":"Mantap"}`, &comment)
+	rt.do(http.MethodPost, path, "u2", `{"type":"COMMENT","comment":"Setuju","parent_id":"`+comment.ID+`"}`, &reply)
+	rt.flush()
+
+	if status := rt.do(http.MethodDelete, path+"/"+comment.ID, "u2", "", nil); status != http.StatusForbidden {
+		t.Errorf("DELETE by other user = %d, want 403", status)
+	}
+	if status := rt.do(http.MethodDelete, path+"/"+comment.ID, "u1", "", nil); status != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want 204", status)
+	}
+	if len(rt.events.msgs) != 1 {
+		t.Fatalf("Published %d events, want 1", len(rt.events.msgs))
+	}
+
+	// replies are deleted by events handler
+	rt.flush()
+	if _, err := rt.db.GetResponse(ctx, reply.ID); err != store.ErrNotFound {
+		t.Errorf("GetResponse(reply) = %v, want ErrNotFound", err)
+	}
+	if n := rt.count("comment_count"); n != 1 {
+		t.Errorf("comment_count = %d, want 1", n)
+	}
+}
@@ -4,10 +4,7 @@ import (
 	"context"
 	"errors"
 
-	fs "cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
-
-	"server/common/constant"
+	"server/common/store"
 )
 
 type queryopts struct {
@@ -20,51 +17,54 @@ type queryopts struct {
 
 // getFeeds returns a list of feeds
 func (h *Handler) getFeeds(ctx context.Context) ([]map[string]interface{}, error) {
-	if err := h.google.InitFirestore(ctx); err != nil {
+	feeds, err := h.store.ListFeeds(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	iter := h.google.Firestore.Collection(constant.Feeds).Documents(ctx)
 	var items []map[string]interface{}
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			continue
-		}
-		data := doc.Data()
+	for _, feed := range feeds {
 		items = append(items, map[string]interface{}{
-			"id":    data["id"],
-			"title": data["title"],
+			"id":    feed.ID,
+			"title": feed.Title,
 		})
 	}
 	return items, nil
 }
 
+// getCollections returns the registered entries collections with their entry count
+func (h *Handler) getCollections(ctx context.Context) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	for _, c := range h.routes.Registry() {
+		count, err := h.store.CountEntries(ctx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		item := map[string]interface{}{
+			"name":         c.Name,
+			"title":        c.Title,
+			"path":         entriesPath(c.Name),
+			"category_ids": c.CategoryIDs,
+			"feed_ids":     c.FeedIDs,
+			"entry_count":  count,
+		}
+		if c.CategoryMin > 0 {
+			item["category_min"] = c.CategoryMin
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
 // getEntry returns single entry based on specified collection name and entry ID
 func (h *Handler) getEntry(ctx context.Context, opts queryopts) (map[string]interface{}, error) {
 	if opts.Collection == "" || opts.ID == "" {
 		return nil, errors.New("missing Collection or ID in queryopts")
 	}
-
-	if err := h.google.InitFirestore(ctx); err != nil {
-		return nil, err
-	}
-
-	entry, err := h.google.Firestore.Collection(opts.Collection).Doc(opts.ID).Get(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return entry.Data(), nil
+	return h.store.GetEntry(ctx, opts.Collection, opts.ID)
 }
 
 func (h *Handler) getEntries(ctx context.Context, opts queryopts) ([]map[string]interface{}, error) {
-	if err := h.google.InitFirestore(ctx); err != nil {
-		return nil, err
-	}
-
 	// default limit 10
 	// max limit 20
 	if opts.Limit == 0 {
@@ -73,25 +73,10 @@ func (h *Handler) getEntries(ctx context.Context, opts queryopts) ([]map[string]
 		opts.Limit = 20
 	}
 
-	query := h.google.Firestore.Collection(opts.Collection).OrderBy("published_at", fs.Desc).Limit(opts.Limit)
-	if opts.Cursor > 0 {
-		query = query.StartAfter(opts.Cursor)
-	}
-	if opts.Category > 0 {
-		query = query.Where("category_id", "==", opts.Category)
-	}
-
-	iter := query.Documents(ctx)
-	var items []map[string]interface{}
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			continue
-		}
-		items = append(items, doc.Data())
-	}
-	return items, nil
+	return h.store.ListEntries(ctx, store.EntryQuery{
+		Collection: opts.Collection,
+		Cursor:     int64(opts.Cursor),
+		CategoryID: int64(opts.Category),
+		Limit:      opts.Limit,
+	})
 }
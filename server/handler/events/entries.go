@@ -7,10 +7,9 @@ import (
 	"log"
 	"strconv"
 
-	"cloud.google.com/go/pubsub"
-	"google.golang.org/api/iterator"
-
-	"server/common/constant"
+	"server/common/fault"
+	"server/common/store"
+	"server/common/transport"
 	"server/common/types"
 	"server/config"
 )
@@ -25,14 +24,20 @@ type entryData struct {
 
 // notifySubscriber triggered when new entry written to Firestore,
 // get the list of the subscribers for the category of this entry and send a message to PushNotification topic.
-// TODO: this method is too long!
-func (h *Handler) notifySubscribers(ctx context.Context, pubsubData []byte) error {
+func (h *Handler) notifySubscribers(ctx context.Context, msgID string, pubsubData []byte) error {
 
 	var data *entryData
 	if err := json.Unmarshal(pubsubData, &data); err != nil {
 		return err
 	}
 
+	return h.publishToSubscribers(ctx, eventKey("entries", msgID, data.ID, data.Timestamp), data)
+}
+
+// publishToSubscribers publishes a PushNotification message for each subscriber of the entry category.
+// Every subscriber is claimed in the processed-message ledger with key prefix, so the redelivered event
+// finishes the fan-out interrupted by a crash or failed publish without notifying anyone twice.
+func (h *Handler) publishToSubscribers(ctx context.Context, key string, data *entryData) error {
 	entryTitle := data.Entry.Title
 	entryID := strconv.FormatInt(data.Entry.ID, 10)
 	categoryID := strconv.FormatInt(data.Entry.CategoryID, 10)
@@ -49,28 +54,21 @@ func (h *Handler) notifySubscribers(ctx context.Context, pubsubData []byte) erro
 		}
 	}
 
-	// overrides subscriberCategory if feedID belongs to BaleBengong
-	// for bale bengong subscriber they subscribes on a separate category called "balebengong"
-	subscriberCategory := categoryID
-	baleBengongFeeds := []string{"33", "34", "35", "36", "37", "38", "39", "40"}
-	for _, ID := range baleBengongFeeds {
-		if ID == feedID {
-			subscriberCategory = "balebengong"
-			break
-		}
-	}
+	// some routes (eg. BaleBengong) subscribes on a separate category instead of the entry category
+	subscriberCategory := h.routes.SubscriberTopic(data.Entry.CategoryID, data.Entry.FeedID)
 
 	// Get the category
-	doc, err := h.google.Firestore.Collection(constant.Categories).Doc(subscriberCategory).Get(ctx)
+	category, err := h.store.GetCategory(ctx, subscriberCategory)
+	if err == store.ErrNotFound {
+		return fault.Permanentf("Category with ID=%v does not exists", subscriberCategory)
+	}
 	if err != nil {
-		return fmt.Errorf("Category with ID=%v does not exists", subscriberCategory)
+		return err
 	}
 
-	category := doc.Data()
-
 	// create message to publish to PushNotification topic.
 	pushData := types.PushNotificationPayload{
-		Title: category["title"].(string),
+		Title: category.Title,
 		Body:  entryTitle,
 		Image: entryImage,
 		Data: map[string]string{
@@ -79,37 +77,49 @@ func (h *Handler) notifySubscribers(ctx context.Context, pubsubData []byte) erro
 			"entry_title":    entryTitle,
 			"entry_id":       entryID,
 			"category_id":    categoryID, // original category ID
-			"category_title": category["title"].(string),
+			"category_title": category.Title,
 			"feed_id":        feedID,
 			"published_at":   publishedAt,
 		},
+		APNS:     &types.APNSOptions{ThreadID: TopicName(subscriberCategory)}, // group by category
+		Category: subscriberCategory,
 	}
 
-	// get subscribers
-	iter := h.google.Firestore.
-		Collection(fmt.Sprintf("categories/%v/subscribers", subscriberCategory)).
-		Documents(ctx)
-
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
+	// single FCM topic message reaches all subscribers
+	if topicMode(subscriberCategory) {
+		pushData.Topic = TopicName(subscriberCategory)
+		j, err := json.Marshal(pushData)
 		if err != nil {
-			continue
+			return err
 		}
+		return store.Once(ctx, h.store, key, config.DedupTTL, func() error {
+			_, err := h.publisher.Publish(ctx, config.PushNotificationTopic, &transport.Message{Data: j})
+			return err
+		})
+	}
+
+	// get subscribers
+	subscribers, err := h.store.ListSubscribers(ctx, subscriberCategory)
+	if err != nil {
+		return err
+	}
 
-		subscriber := doc.Data()
-		pushData.UserID = subscriber["user_id"].(string) // set recipient
+	failed := 0
+	for _, subscriber := range subscribers {
+		pushData.UserID = subscriber.UserID // set recipient
 
 		// check to see if user exists before publishing a message.
 		// if user does not exists, delete them from subscriber list.
-		if !h.isUserExists(ctx, pushData.UserID) {
-			if _, err := doc.Ref.Delete(ctx); err != nil {
+		user, err := h.store.GetUser(ctx, pushData.UserID)
+		if err != nil {
+			if err := h.store.DeleteSubscriber(ctx, subscriberCategory, subscriber.ID); err != nil {
 				log.Printf("Failed to delete subscriber %v from category %v\n", pushData.UserID, subscriberCategory)
 			}
 			continue
 		}
+		if !user.Preferences.AllowsCategory(subscriberCategory) {
+			continue
+		}
 
 		j, err := json.Marshal(pushData)
 		if err != nil {
@@ -117,17 +127,19 @@ func (h *Handler) notifySubscribers(ctx context.Context, pubsubData []byte) erro
 			continue
 		}
 
-		pubsubMsg := &pubsub.Message{Data: j}
-		if _, err = h.google.PublishToTopic(ctx, config.PushNotificationTopic, pubsubMsg); err != nil {
+		err = store.Once(ctx, h.store, key+":"+subscriber.UserID, config.DedupTTL, func() error {
+			_, err := h.publisher.Publish(ctx, config.PushNotificationTopic, &transport.Message{Data: j})
+			return err
+		})
+		if err != nil && err != store.ErrDuplicate {
 			log.Println("notifySubscribers(): publish to Push topic failed:", err)
+			failed++
 		}
 	}
 
+	if failed > 0 {
+		// the claims of failed ones are released, only those are published again
+		return fault.Retryable(fmt.Errorf("publish to %d of %d subscribers failed", failed, len(subscribers)))
+	}
 	return nil
 }
-
-// h.isUserExists check to see if user with given ID is currently exists.
-func (h *Handler) isUserExists(ctx context.Context, userID string) bool {
-	_, err := h.google.Firestore.Collection("users").Doc(userID).Get(ctx)
-	return err == nil
-}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"server/common/fault"
+	"server/common/routing"
+	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+)
+
+// flakyPublisher fails the first publish to user failUser
+type flakyPublisher struct {
+	failUser string
+	failed   bool
+	users    map[string]int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, topic string, msg *transport.Message) (string, error) {
+	var payload types.PushNotificationPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return "", err
+	}
+	if payload.UserID == p.failUser && !p.failed {
+		p.failed = true
+		return "", errors.New("unavailable")
+	}
+	p.users[payload.UserID]++
+	return "", nil
+}
+
+func TestNotifySubscribersRedelivery(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+	db.SaveCategories(ctx, []types.Category{{ID: 1, Title: "Berita"}})
+	for _, id := range []string{"u1", "u2", "u3"} {
+		db.SaveUser(ctx, &types.User{ID: id})
+		db.AddSubscriber(ctx, "1", &types.Subscriber{ID: id, UserID: id})
+	}
+
+	p := &flakyPublisher{failUser: "u2", users: make(map[string]int)}
+	h := New(db, p, service.NewLocal(), routing.Default())
+	msg := &transport.Message{
+		ID:         "1",
+		Data:       []byte(`{"id":"1","timestamp":"2020-01-10T08:00:00Z","data":{"id":1,"category_id":1,"feed_id":1,"title":"Berita"}}`),
+		Attributes: map[string]string{"type": "entries"},
+	}
+
+	if err := h.Process(ctx, msg); err == nil || !fault.IsRetryable(err) {
+		t.Errorf("Process() = %v, want retryable error", err)
+	}
+	// the redelivered event only publishes to the failed subscriber
+	if err := h.Process(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Process(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.users) != 3 || p.users["u1"] != 1 || p.users["u2"] != 1 || p.users["u3"] != 1 {
+		t.Errorf("Subscribers should be notified once, got %v", p.users)
+	}
+}
@@ -3,22 +3,31 @@ package events
 import (
 	"context"
 	"errors"
+	"log"
 	"net/http"
 
 	pubs "github.com/fiberweb/pubsub"
 	"github.com/gofiber/fiber"
 
+	"server/common/fault"
+	"server/common/routing"
 	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
+	"server/config"
 )
 
 // Handler represents the handler for Firestore events
 type Handler struct {
-	google *service.Google
+	store     store.Store
+	publisher transport.Publisher
+	messenger service.Messenger
+	routes    *routing.Table
 }
 
 // New returns Handler instance
-func New(g *service.Google) *Handler {
-	return &Handler{google: g}
+func New(s store.Store, p transport.Publisher, m service.Messenger, routes *routing.Table) *Handler {
+	return &Handler{store: s, publisher: p, messenger: m, routes: routes}
 }
 
 // Handle handles the request
@@ -26,38 +35,60 @@ func (h *Handler) Handle() func(*fiber.Ctx) {
 	return func(c *fiber.Ctx) {
 		msg, ok := c.Locals(pubs.LocalsKey).(*pubs.Message)
 		if !ok {
-			c.Next(errors.New("unable to retrieve PubSub message from c.Locals"))
+			c.Next(fault.Permanent(errors.New("unable to retrieve PubSub message from c.Locals")))
 			return
 		}
-
-		// check message attributes
-		attrs := msg.Message.Attributes
-		datatype, ok := attrs["type"]
-		if !ok {
-			c.Next(errors.New("type is missing from PubSub message attributes"))
+		if err := h.Process(context.Background(), transport.FromPush(c, msg)); err != nil {
+			c.Next(err)
 			return
 		}
+		c.SendStatus(http.StatusOK)
+	}
+}
 
-		ctx := context.Background()
+// Process handles Firestore event message, duplicate event is ignored
+func (h *Handler) Process(ctx context.Context, msg *transport.Message) error {
+	// check message attributes
+	datatype, ok := msg.Attributes["type"]
+	if !ok {
+		return fault.Permanent(errors.New("type is missing from PubSub message attributes"))
+	}
 
-		// initialize firestore in case not yet initialize
-		if err := h.google.InitFirestore(ctx); err != nil {
-			c.Next(err)
-			return
-		}
+	var err error
+	switch datatype {
+	case "entries":
+		err = h.notifySubscribers(ctx, msg.ID, msg.Data)
+	case "responses":
+		err = h.aggregateResponses(ctx, msg.ID, msg.Data)
+	case "subscribers":
+		err = h.syncSubscriber(ctx, msg.ID, msg.Data)
+	}
+	if err == store.ErrDuplicate {
+		log.Println("[DUPLICATE] event message", msg.ID)
+		return nil
+	}
+	return err
+}
 
-		switch datatype.(string) {
-		case "entries":
-			if err := h.notifySubscribers(ctx, msg.Message.Data); err != nil {
-				c.Next(err)
-				return
-			}
-		case "responses":
-			if err := h.aggregateResponses(ctx, msg.Message.Data); err != nil {
-				c.Next(err)
-				return
-			}
-		}
-		c.SendStatus(http.StatusOK)
+// eventKey returns the processed-message ledger key of Firesub event,
+// Firesub may publish the same document change more than once so it's keyed by document ID and change timestamp.
+// Falls back to Pub/Sub message ID when the event has no timestamp.
+func eventKey(kind, msgID, docID, timestamp string) string {
+	if docID == "" || timestamp == "" {
+		return kind + ":" + msgID
+	}
+	return kind + ":" + docID + ":" + timestamp
+}
+
+// claim records the event in the processed-message ledger inside transaction,
+// returns store.ErrDuplicate to abort the transaction when it's already processed.
+func claim(tx store.Tx, key string) error {
+	claimed, err := tx.ClaimMessage(key, config.DedupTTL)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return store.ErrDuplicate
 	}
+	return nil
 }
@@ -7,157 +7,151 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/pubsub"
-
-	"server/common/constant"
-	"server/common/service"
+	"server/common/routing"
+	"server/common/store"
+	"server/common/transport"
 	"server/common/types"
 	"server/config"
 )
 
-type user struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Avatar string `json:"avatar"`
-}
-
 type response struct {
-	google *service.Google
-
-	UserID          string      `json:"user_id"`
-	Type            string      `json:"type"`
-	EntryID         int64       `json:"entry_id"`
-	EntryCategoryID int64       `json:"entry_category_id"`
-	EntryFeedID     int64       `json:"entry_feed_id"`
-	ParentID        string      `json:"parent_id"` // level 0 or level n
-	ThreadID        string      `json:"thread_id"` // level 0
-	Reaction        string      `json:"reaction"`
-	Comment         string      `json:"comment"`
-	Entry           types.Entry `json:"entry"`
-	User            user        `json:"user"`
+	types.Response
+
+	store     store.Store
+	publisher transport.Publisher
+	routes    *routing.Table
 }
 
-func (r *response) setGoogle(g *service.Google) *response {
-	r.google = g
+func (r *response) setHandler(h *Handler) *response {
+	r.store = h.store
+	r.publisher = h.publisher
+	r.routes = h.routes
 	return r
 }
 
 // deleteReplies deletes all replies for this comment
 func (r *response) deleteReplies(ctx context.Context, ID string) error {
 	// top level comment, delete all replies
-	if r.ThreadID == "" {
-		iter := r.google.Firestore.Collection(constant.EntryResponses).Where("thread_id", "==", ID).Documents(ctx)
-		snaps, err := iter.GetAll()
-		if err != nil {
-			return err
-		}
-		if len(snaps) > 0 {
-			batch := r.google.Firestore.Batch()
-			for _, snap := range snaps {
-				batch.Delete(snap.Ref)
-			}
-			_, err := batch.Commit(ctx)
-			return err
-		}
-	}
-
 	// a reply, delete all replies (childs) to this reply
+	q := store.ResponseQuery{ThreadID: ID}
 	if r.ThreadID != "" {
-		iter := r.google.Firestore.Collection(constant.EntryResponses).Where("parent_id", "==", ID).Documents(ctx)
-		snaps, err := iter.GetAll()
-		if err != nil {
-			return err
-		}
-		if len(snaps) > 0 {
-			batch := r.google.Firestore.Batch()
-			for _, snap := range snaps {
-				batch.Delete(snap.Ref)
-			}
-			_, err := batch.Commit(ctx)
-			return err
-		}
+		q = store.ResponseQuery{ParentID: ID}
 	}
 
-	return nil
+	replies, err := r.store.ListResponses(ctx, q)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, reply := range replies {
+		ids = append(ids, reply.ID)
+	}
+	return r.store.DeleteResponses(ctx, ids)
+}
+
+// stampCreatedAt sets created_at of response written without it (eg. by the app directly) to the event timestamp,
+// comments are listed by created_at.
+func (r *response) stampCreatedAt(ctx context.Context, timestamp string) error {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		t = time.Now()
+	}
+	r.CreatedAt = t.UnixNano() / int64(time.Millisecond)
+	err = r.store.UpdateResponse(ctx, r.ID, map[string]interface{}{"created_at": r.CreatedAt})
+	if err == store.ErrNotFound {
+		return nil // already deleted
+	}
+	return err
 }
 
 // -- comment aggregation
-func (r *response) aggregateComment(ctx context.Context, incrementValue int) error {
+func (r *response) aggregateComment(ctx context.Context, key string, incrementValue int) error {
 	entryID := strconv.FormatInt(r.EntryID, 10)
 	categoryID := r.EntryCategoryID
 
+	var parentAuthorID string
+
 	// run inside a transaction
-	return r.google.Firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+	err := r.store.RunTransaction(ctx, func(ctx context.Context, tx store.Tx) error {
 		// -- transaction start
+		parentAuthorID = ""
 
-		// update entry comment count
-		entry := r.google.Firestore.Collection(entryCollectionByCategory(categoryID)).Doc(entryID)
-		update := []firestore.Update{{
-			Path:  "comment_count",
-			Value: firestore.Increment(incrementValue),
-		}}
-		if err := tx.Update(entry, update); err != nil {
+		if err := claim(tx, key); err != nil {
 			return err
 		}
 
-		// if has parent_id and thread_id
-		if r.ParentID != "" && r.ThreadID != "" {
+		var parent *types.Response
+		var thread *types.Response
 
-			var parent *firestore.DocumentSnapshot
-			var thread *firestore.DocumentSnapshot
+		// if has parent_id and thread_id, get them before doing any update
+		if r.ParentID != "" && r.ThreadID != "" {
 			var err error
 
 			// get direct parent
-			parent, err = r.google.Firestore.Collection(constant.EntryResponses).Doc(r.ParentID).Get(ctx)
+			parent, err = tx.GetResponse(r.ParentID)
 			if err != nil {
 				parent = nil
 			}
 
 			// a reply to a reply, get level 0 parent (thread)
 			if r.ParentID != r.ThreadID {
-				thread, _ = r.google.Firestore.Collection(constant.EntryResponses).Doc(r.ThreadID).Get(ctx)
+				thread, err = tx.GetResponse(r.ThreadID)
 				if err != nil {
 					thread = nil
 				}
 			}
+		}
 
-			// if thread found, increment reply_count
-			// otherwise increment reply_count of parent
-			update := []firestore.Update{{
-				Path:  "reply_count",
-				Value: firestore.Increment(incrementValue),
-			}}
-			// update reply count on parent
-			if parent != nil {
-				err = tx.Update(parent.Ref, update)
-				if err != nil {
-					return err
-				}
+		// update entry comment count
+		if err := tx.IncrementEntry(r.routes.Collection(categoryID, r.EntryFeedID), entryID, map[string]int{"comment_count": incrementValue}); err != nil {
+			return err
+		}
+
+		// if thread found, increment reply_count
+		// otherwise increment reply_count of parent
+		// update reply count on parent
+		if parent != nil {
+			if err := tx.IncrementResponse(parent.ID, map[string]int{"reply_count": incrementValue}); err != nil {
+				return err
 			}
-			// update reply count on thread
-			if thread != nil {
-				err = tx.Update(thread.Ref, update)
-				if err != nil {
-					return err
-				}
+		}
+		// update reply count on thread
+		if thread != nil {
+			if err := tx.IncrementResponse(thread.ID, map[string]int{"reply_count": incrementValue}); err != nil {
+				return err
 			}
+		}
 
-			// notify parent comment author
-			if (incrementValue > 0) && (parent != nil) && (parent.Data()["user_id"].(string) != r.UserID) {
-				if err := r.notifyParentAuthor(ctx, parent.Data()["user_id"].(string)); err != nil {
-					log.Printf("[ERROR] %s\n", err)
-				}
-			}
+		if (incrementValue > 0) && (parent != nil) && (parent.UserID != r.UserID) {
+			parentAuthorID = parent.UserID
 		}
 
 		// -- transaction end
 		return nil
 	})
+	if err == store.ErrNotFound {
+		return nil // entry already deleted together with its counters
+	}
+	if err != nil {
+		return err
+	}
+
+	// notify parent comment author
+	if parentAuthorID != "" {
+		if err := r.notifyParentAuthor(ctx, parentAuthorID); err != nil {
+			log.Printf("[ERROR] %s\n", err)
+		}
+	}
+	return nil
 }
 
 func (r *response) notifyParentAuthor(ctx context.Context, parentAuthorID string) error {
+	if user, err := r.store.GetUser(ctx, parentAuthorID); err == nil && !user.Preferences.AllowsReplies() {
+		return nil
+	}
+
 	payload := types.PushNotificationPayload{
 		UserID: parentAuthorID, // to user
 		Title:  fmt.Sprintf("%s membalas komentar anda:", r.User.Name),
@@ -166,18 +160,20 @@ func (r *response) notifyParentAuthor(ctx context.Context, parentAuthorID string
 		Data: map[string]string{
 			"click_action": "FLUTTER_NOTIFICATION_CLICK",
 			"data_type":    "response",
+			"response_id":  r.ID,
 			"entry_title":  r.Entry.Title,
 			"entry_id":     strconv.FormatInt(r.Entry.ID, 10),
 			"category_id":  strconv.FormatInt(r.Entry.CategoryID, 10),
 			"feed_id":      strconv.FormatInt(r.Entry.FeedID, 10),
 			"published_at": strconv.FormatInt(r.Entry.PublishedAt, 10),
 		},
+		APNS: &types.APNSOptions{ThreadID: "entry_" + strconv.FormatInt(r.Entry.ID, 10)}, // group by entry
 	}
 	j, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
-	_, err = r.google.PublishToTopic(ctx, config.PushNotificationTopic, &pubsub.Message{Data: j})
+	_, err = r.publisher.Publish(ctx, config.PushNotificationTopic, &transport.Message{Data: j})
 	if err != nil {
 		log.Println("notifyParentAuthor(): publish to Push topic failed:", err)
 	}
@@ -185,19 +181,21 @@ func (r *response) notifyParentAuthor(ctx context.Context, parentAuthorID string
 }
 
 // -- reaction aggregation
-func (r *response) aggregateReactionCreateDelete(ctx context.Context, incrementValue int) error {
+func (r *response) aggregateReactionCreateDelete(ctx context.Context, key string, incrementValue int) error {
 	entryID := strconv.FormatInt(r.EntryID, 10)
 	categoryID := r.EntryCategoryID
-	reaction := r.Reaction
-
-	update := []firestore.Update{{
-		Path:  fmt.Sprintf("reaction_%s_count", strings.ToLower(reaction)),
-		Value: firestore.Increment(incrementValue),
-	}}
-	_, err := r.google.Firestore.
-		Collection(entryCollectionByCategory(categoryID)).
-		Doc(entryID).
-		Update(ctx, update)
+	counters := map[string]int{
+		fmt.Sprintf("reaction_%s_count", strings.ToLower(r.Reaction)): incrementValue,
+	}
 
+	err := r.store.RunTransaction(ctx, func(ctx context.Context, tx store.Tx) error {
+		if err := claim(tx, key); err != nil {
+			return err
+		}
+		return tx.IncrementEntry(r.routes.Collection(categoryID, r.EntryFeedID), entryID, counters)
+	})
+	if err == store.ErrNotFound {
+		return nil // entry already deleted together with its counters
+	}
 	return err
 }
@@ -4,11 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"server/common/constant"
 	"strconv"
 	"strings"
 
-	"cloud.google.com/go/firestore"
+	"server/common/store"
 )
 
 const (
@@ -25,59 +24,67 @@ type responseData struct {
 	After     *response `json:"after"`
 }
 
-func (h *Handler) aggregateResponses(ctx context.Context, pubsubData []byte) error {
+func (h *Handler) aggregateResponses(ctx context.Context, msgID string, pubsubData []byte) error {
 	var data *responseData
 	if err := json.Unmarshal(pubsubData, &data); err != nil {
 		return err
 	}
+	key := eventKey("responses", msgID, data.ID, data.Timestamp)
 
 	// on created
 	if (data.Before == nil) && (data.After != nil) {
-		after := data.After.setGoogle(h.google)
+		after := data.After.setHandler(h)
+		after.ID = data.ID
+
+		if after.CreatedAt == 0 {
+			if err := after.stampCreatedAt(ctx, data.Timestamp); err != nil {
+				return err
+			}
+		}
 
 		switch after.Type {
 		case typeComment:
-			return after.aggregateComment(ctx, 1)
+			return after.aggregateComment(ctx, key, 1)
 		case typeReaction:
-			return after.aggregateReactionCreateDelete(ctx, 1)
+			return after.aggregateReactionCreateDelete(ctx, key, 1)
 		}
 	}
 
 	// on updated
 	// only process REACTION update, comment update does not affect comments count.
 	if (data.Before != nil) && (data.After != nil) {
-		after := data.After.setGoogle(h.google)
+		after := data.After.setHandler(h)
 
 		if after.Type == typeReaction {
-			return aggregateReactionUpdate(ctx, data.Before, after)
+			return aggregateReactionUpdate(ctx, key, data.Before, after)
 		}
 	}
 
 	// on deleted
 	if (data.Before != nil) && (data.After == nil) {
-		before := data.Before.setGoogle(h.google)
+		before := data.Before.setHandler(h)
 
 		switch before.Type {
 		case typeComment:
 			// aggregator
-			err := before.aggregateComment(ctx, -1)
-			if err != nil {
+			err := before.aggregateComment(ctx, key, -1)
+			if err != nil && err != store.ErrDuplicate {
 				return err
 			}
-			// delete replies if any
+			// delete replies if any, safe to repeat on redelivery
 			err = before.deleteReplies(ctx, data.ID)
 			if err != nil {
 				return err
 			}
 		case typeReaction:
-			return before.aggregateReactionCreateDelete(ctx, -1)
+			return before.aggregateReactionCreateDelete(ctx, key, -1)
 		}
 	}
 
 	return nil
 }
 
-func aggregateReactionUpdate(ctx context.Context, before, after *response) error {
+func aggregateReactionUpdate(ctx context.Context, key string, before, after *response) error {
 	entryID := strconv.FormatInt(after.EntryID, 10)
 	categoryID := after.EntryCategoryID
 	newReaction := after.Reaction
@@ -87,33 +94,18 @@ func aggregateReactionUpdate(ctx context.Context, before, after *response) error
 		return nil
 	}
 
-	update := []firestore.Update{
-		{
-			Path:  fmt.Sprintf("reaction_%s_count", strings.ToLower(oldReaction)),
-			Value: firestore.Increment(-1),
-		},
-		{
-			Path:  fmt.Sprintf("reaction_%s_count", strings.ToLower(newReaction)),
-			Value: firestore.Increment(1),
-		},
+	counters := map[string]int{
+		fmt.Sprintf("reaction_%s_count", strings.ToLower(oldReaction)): -1,
+		fmt.Sprintf("reaction_%s_count", strings.ToLower(newReaction)): 1,
 	}
-	_, err := after.google.Firestore.
-		Collection(entryCollectionByCategory(categoryID)).
-		Doc(entryID).
-		Update(ctx, update)
-
-	return err
-}
-
-// entryCollectionByCategory method is specific to BaliFeed app only
-func entryCollectionByCategory(categoryID int64) string {
-	c := constant.Entries
-	if categoryID == 11 {
-		c = constant.Kriminal
-	} else if categoryID == 12 {
-		c = constant.BaliUnited
-	} else if categoryID > 12 {
-		c = constant.BaleBengong
+	err := after.store.RunTransaction(ctx, func(ctx context.Context, tx store.Tx) error {
+		if err := claim(tx, key); err != nil {
+			return err
+		}
+		return tx.IncrementEntry(after.routes.Collection(categoryID, after.EntryFeedID), entryID, counters)
+	})
+	if err == store.ErrNotFound {
+		return nil // entry already deleted together with its counters
 	}
-	return c
+	return err
 }
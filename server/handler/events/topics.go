@@ -0,0 +1,177 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"server/common/store"
+	"server/common/types"
+	"server/config"
+)
+
+// maxTopicTokens is the max tokens of single FCM topic (un)subscription
+const maxTopicTokens = 1000
+
+// TopicName returns the FCM topic of subscriber category
+func TopicName(categoryID string) string {
+	return "category_" + categoryID
+}
+
+// topicMode reports whether subscribers of category are notified by FCM topic message
+func topicMode(categoryID string) bool {
+	for _, c := range config.TopicCategories {
+		if c == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// this is based PubSub data format sent by Firesub, with the category of categories/{id}/subscribers
+type subscriberData struct {
+	ID         string            `json:"id"`
+	Timestamp  string            `json:"timestamp"`
+	CategoryID string            `json:"category_id"`
+	Before     *types.Subscriber `json:"before"`
+	After      *types.Subscriber `json:"after"`
+}
+
+// syncSubscriber keeps FCM topic subscription of the user devices in sync with the subscribers of topic mode category
+func (h *Handler) syncSubscriber(ctx context.Context, msgID string, pubsubData []byte) error {
+	var data *subscriberData
+	if err := json.Unmarshal(pubsubData, &data); err != nil {
+		return err
+	}
+	if !topicMode(data.CategoryID) {
+		return nil
+	}
+
+	topic := TopicName(data.CategoryID)
+	// the key is a Firestore document ID, it can't contain "/"
+	key := eventKey("subscribers", msgID, data.CategoryID+"_"+data.ID, data.Timestamp)
+	return store.Once(ctx, h.store, key, config.DedupTTL, func() error {
+		if data.Before != nil && (data.After == nil || data.After.UserID != data.Before.UserID) {
+			if err := h.subscribeUser(ctx, topic, data.Before.UserID, false); err != nil {
+				return err
+			}
+		}
+		if data.After != nil && (data.Before == nil || data.After.UserID != data.Before.UserID) {
+			if err := h.subscribeUser(ctx, topic, data.After.UserID, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// subscribeUser subscribes (or unsubscribes) devices of the user to FCM topic and records the topic user
+func (h *Handler) subscribeUser(ctx context.Context, topic, userID string, subscribe bool) error {
+	if err := h.updateTopic(ctx, topic, h.userTokens(ctx, userID), subscribe); err != nil {
+		return err
+	}
+	return h.store.SetTopicUser(ctx, topic, userID, subscribe)
+}
+
+// TopicReport is the result of topic subscriptions sync
+type TopicReport struct {
+	CategoryID   string `json:"category_id"`
+	Topic        string `json:"topic"`
+	Subscribers  int    `json:"subscribers"`
+	Tokens       int    `json:"tokens"`
+	Unsubscribed int    `json:"unsubscribed"`
+}
+
+// SyncTopics subscribes devices of all subscribers of topic mode categories to the category topic,
+// to catch up new devices and missed subscriber events, and unsubscribes devices of topic users
+// who are no longer subscribers (subscriber events are only sent by the listener).
+func (h *Handler) SyncTopics(ctx context.Context) ([]*TopicReport, error) {
+	var reports []*TopicReport
+	for _, categoryID := range config.TopicCategories {
+		subscribers, err := h.store.ListSubscribers(ctx, categoryID)
+		if err != nil {
+			return reports, err
+		}
+		topic := TopicName(categoryID)
+		users, err := h.store.ListTopicUsers(ctx, topic)
+		if err != nil {
+			return reports, err
+		}
+
+		report := &TopicReport{CategoryID: categoryID, Topic: topic, Subscribers: len(subscribers)}
+		reports = append(reports, report)
+
+		current := make(map[string]bool, len(subscribers))
+		var tokens []string
+		for _, s := range subscribers {
+			if !current[s.UserID] {
+				current[s.UserID] = true
+				tokens = append(tokens, h.userTokens(ctx, s.UserID)...)
+			}
+		}
+		for _, userID := range users {
+			if current[userID] {
+				continue
+			}
+			if err := h.subscribeUser(ctx, topic, userID, false); err != nil {
+				return reports, err
+			}
+			report.Unsubscribed++
+		}
+
+		report.Tokens = len(tokens)
+		if err := h.updateTopic(ctx, topic, tokens, true); err != nil {
+			return reports, err
+		}
+		recorded := make(map[string]bool, len(users))
+		for _, userID := range users {
+			recorded[userID] = true
+		}
+		for userID := range current {
+			if recorded[userID] {
+				continue
+			}
+			if err := h.store.SetTopicUser(ctx, topic, userID, true); err != nil {
+				return reports, err
+			}
+		}
+	}
+	return reports, nil
+}
+
+// userTokens returns FCM tokens of user, nil when user does not exists
+func (h *Handler) userTokens(ctx context.Context, userID string) []string {
+	user, err := h.store.GetUser(ctx, userID)
+	if err != nil {
+		return nil
+	}
+	var tokens []string
+	for token := range user.FCMTokens {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// updateTopic subscribes (or unsubscribes) tokens to FCM topic in batches of maxTopicTokens
+func (h *Handler) updateTopic(ctx context.Context, topic string, tokens []string, subscribe bool) error {
+	for start := 0; start < len(tokens); start += maxTopicTokens {
+		end := start + maxTopicTokens
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+
+		update := h.messenger.UnsubscribeFromTopic
+		if subscribe {
+			update = h.messenger.SubscribeToTopic
+		}
+		res, err := update(ctx, tokens[start:end], topic)
+		if err != nil {
+			return err
+		}
+		for _, e := range res.Errors {
+			// invalid tokens, pruned on the next push to the user
+			log.Printf("[ERROR] topic %s token #%d: %s\n", topic, start+e.Index, e.Reason)
+		}
+	}
+	return nil
+}
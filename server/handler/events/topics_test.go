@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"firebase.google.com/go/messaging"
+
+	"server/common/routing"
+	"server/common/service"
+	"server/common/store"
+	"server/common/types"
+	"server/config"
+)
+
+// topicMessenger records the tokens (un)subscribed to topics
+type topicMessenger struct {
+	*service.Local
+	subscribed   map[string]bool
+	unsubscribed map[string]bool
+}
+
+func (m *topicMessenger) SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	for _, token := range tokens {
+		m.subscribed[token] = true
+	}
+	return &messaging.TopicManagementResponse{SuccessCount: len(tokens)}, nil
+}
+
+func (m *topicMessenger) UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	for _, token := range tokens {
+		m.unsubscribed[token] = true
+	}
+	return &messaging.TopicManagementResponse{SuccessCount: len(tokens)}, nil
+}
+
+func TestSyncTopics(t *testing.T) {
+	defer func(categories []string) { config.TopicCategories = categories }(config.TopicCategories)
+	config.TopicCategories = []string{"13"}
+
+	ctx := context.Background()
+	db := store.NewMemory()
+	db.SaveUser(ctx, &types.User{ID: "u1", FCMTokens: map[string]interface{}{"t1": true}})
+	db.SaveUser(ctx, &types.User{ID: "u2", FCMTokens: map[string]interface{}{"t2": true}})
+	db.AddSubscriber(ctx, "13", &types.Subscriber{ID: "s1", UserID: "u1"})
+	db.AddSubscriber(ctx, "13", &types.Subscriber{ID: "s2", UserID: "u2"})
+
+	m := &topicMessenger{Local: service.NewLocal(), subscribed: map[string]bool{}, unsubscribed: map[string]bool{}}
+	h := New(db, nil, m, routing.Default())
+	if _, err := h.SyncTopics(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !m.subscribed["t1"] || !m.subscribed["t2"] {
+		t.Errorf("Subscribers devices should be subscribed, got %v", m.subscribed)
+	}
+
+	// u2 unsubscribed without subscriber event
+	db.DeleteSubscriber(ctx, "13", "s2")
+	reports, err := h.SyncTopics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Unsubscribed != 1 || !m.unsubscribed["t2"] || m.unsubscribed["t1"] {
+		t.Errorf("Unexpected unsubscribed %+v, %v", reports[0], m.unsubscribed)
+	}
+	if users, _ := db.ListTopicUsers(ctx, TopicName("13")); len(users) != 1 || users[0] != "u1" {
+		t.Errorf("ListTopicUsers() = %v, want [u1]", users)
+	}
+}
@@ -0,0 +1,81 @@
+package push
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"server/common/types"
+)
+
+// digestTitles is max entry titles in digest notification body
+const digestTitles = 3
+
+// digestKey returns the key grouping buffered entries of user and category into single digest.
+// It's part of Firestore document IDs (pending notifications and processed-message ledger) so it can't contain "/".
+func digestKey(userID, categoryID string) string {
+	return userID + "_" + categoryID
+}
+
+// digestEntry is an entry listed in digest notification data
+type digestEntry struct {
+	EntryID     string `json:"entry_id"`
+	EntryTitle  string `json:"entry_title"`
+	CategoryID  string `json:"category_id"`
+	FeedID      string `json:"feed_id"`
+	PublishedAt string `json:"published_at"`
+}
+
+// digestPayload returns single notification of buffered entry notifications of the same user and category,
+// the entries are listed as JSON array in data "entries".
+func digestPayload(pending []types.PendingNotification) *types.PushNotificationPayload {
+	first := pending[0].Payload
+	categoryTitle := first.Data["category_title"]
+	if categoryTitle == "" {
+		categoryTitle = first.Title
+	}
+
+	entries := make([]digestEntry, 0, len(pending))
+	var titles []string
+	var image string
+	for _, n := range pending {
+		d := n.Payload.Data
+		entries = append(entries, digestEntry{
+			EntryID:     d["entry_id"],
+			EntryTitle:  d["entry_title"],
+			CategoryID:  d["category_id"],
+			FeedID:      d["feed_id"],
+			PublishedAt: d["published_at"],
+		})
+		if len(titles) < digestTitles {
+			titles = append(titles, n.Payload.Body)
+		}
+		if image == "" {
+			image = n.Payload.Image
+		}
+	}
+	body := strings.Join(titles, "\n")
+	if more := len(pending) - len(titles); more > 0 {
+		body += fmt.Sprintf("\ndan %d lainnya", more)
+	}
+	list, _ := json.Marshal(entries)
+
+	return &types.PushNotificationPayload{
+		UserID:      first.UserID,
+		Category:    first.Category,
+		Title:       fmt.Sprintf("%d berita baru di %s", len(pending), categoryTitle),
+		Body:        body,
+		Image:       image,
+		CollapseKey: "digest_" + first.Category,
+		APNS:        first.APNS,
+		Data: map[string]string{
+			"click_action":   "FLUTTER_NOTIFICATION_CLICK",
+			"data_type":      "digest",
+			"category_id":    first.Category,
+			"category_title": categoryTitle,
+			"count":          strconv.Itoa(len(pending)),
+			"entries":        string(list),
+		},
+	}
+}
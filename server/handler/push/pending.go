@@ -0,0 +1,124 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiberweb/pubsub"
+	"github.com/gofiber/fiber"
+
+	"server/common/fault"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+	"server/config"
+)
+
+// flushLimit is max pending notifications sent per flush
+const flushLimit = 500
+
+// HandleFlush handles the request to send due pending notifications, eg. triggered by Cloud Scheduler
+func (h *Handler) HandleFlush() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		msg, ok := c.Locals(pubsub.LocalsKey).(*pubsub.Message)
+		if !ok {
+			c.Next(fault.Permanent(errors.New("unable to retrieve PubSub message from c.Locals")))
+			return
+		}
+
+		flushed, err := h.FlushPending(context.Background())
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		log.Printf("[FLUSH] message %s: %d pending notifications\n", msg.Message.ID, flushed)
+		c.SendStatus(http.StatusOK)
+	}
+}
+
+// ProcessFlush sends due pending notifications, the message has no payload
+func (h *Handler) ProcessFlush(ctx context.Context, msg *transport.Message) error {
+	_, err := h.FlushPending(ctx)
+	return err
+}
+
+// FlushPending sends pending notifications due now, user's preferences are applied again.
+// Buffered digest entries of the same user and category are sent as single digest notification.
+// Notifications failed with retryable errors are kept for the next flush.
+// Returns number of notifications removed from pending.
+func (h *Handler) FlushPending(ctx context.Context) (int, error) {
+	pending, err := h.store.ListDuePendingNotifications(ctx, h.now(), flushLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	flushed := 0
+	var digestKeys []string
+	digests := make(map[string][]types.PendingNotification)
+	for _, n := range pending {
+		if n.DigestKey != "" {
+			if _, ok := digests[n.DigestKey]; !ok {
+				digestKeys = append(digestKeys, n.DigestKey)
+			}
+			digests[n.DigestKey] = append(digests[n.DigestKey], n)
+			continue
+		}
+		n := n
+		deleted, err := h.flush(ctx, n.ID, n.DueAt, &n.Payload, []string{n.ID})
+		flushed += deleted
+		if err != nil {
+			return flushed, err
+		}
+	}
+
+	for _, key := range digestKeys {
+		entries := digests[key]
+		var ids []string
+		for _, n := range entries {
+			ids = append(ids, n.ID)
+		}
+		id := "digest:" + key + ":" + strconv.FormatInt(entries[0].DueAt.Unix(), 10)
+		deleted, err := h.flush(ctx, id, entries[0].DueAt, digestPayload(entries), ids)
+		flushed += deleted
+		if err != nil {
+			return flushed, err
+		}
+	}
+	return flushed, nil
+}
+
+// flush dispatches the pending payload and deletes pending notifications of given ids,
+// unless it failed with retryable error. Returns number of deleted pending notifications.
+func (h *Handler) flush(ctx context.Context, id string, dueAt time.Time, payload *types.PushNotificationPayload, ids []string) (int, error) {
+	deferred := false
+	key := "pending:" + id + ":" + strconv.FormatInt(dueAt.Unix(), 10)
+	err := store.Once(ctx, h.store, key, config.DedupTTL, func() error {
+		var err error
+		// retryable failures are kept for the next flush, there is no last attempt
+		deferred, err = h.dispatch(ctx, id, payload, false)
+		return err
+	})
+	if err != nil && err != store.ErrDuplicate && fault.IsRetryable(err) {
+		log.Printf("[RETRY] pending notification %s: %s\n", id, err)
+		return 0, nil
+	}
+	if err != nil && err != store.ErrDuplicate {
+		log.Printf("[ERROR] pending notification %s: %s\n", id, err)
+	}
+
+	deleted := 0
+	for _, pendingID := range ids {
+		if deferred && pendingID == id {
+			continue // held again with new due time
+		}
+		if err := h.store.DeletePendingNotification(ctx, pendingID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
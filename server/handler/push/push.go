@@ -7,24 +7,65 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
-	"cloud.google.com/go/firestore"
 	"firebase.google.com/go/messaging"
 	"github.com/fiberweb/pubsub"
 	"github.com/gofiber/fiber"
 
+	"server/common/fault"
 	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
 	"server/common/types"
+	"server/config"
 )
 
+// maxMulticastTokens is the max tokens of single FCM multicast message
+const maxMulticastTokens = 500
+
 // Handler represents the handler for Push notification
 type Handler struct {
-	google *service.Google
+	// MaxRetries is number of retries for tokens failed with retryable FCM errors
+	MaxRetries int
+	// RetryDelay is the delay before retry, doubled on every retry
+	RetryDelay time.Duration
+
+	store     store.Store
+	messenger service.Messenger
+	// location is the default timezone of users quiet hours
+	location *time.Location
+	now      func() time.Time
 }
 
 // New returns an instance of Handler
-func New(google *service.Google) *Handler {
-	return &Handler{google}
+func New(s store.Store, m service.Messenger) *Handler {
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		log.Printf("[ERROR] unknown TIMEZONE %s, using UTC: %s\n", config.Timezone, err)
+		location = time.UTC
+	}
+	return &Handler{
+		MaxRetries: config.PushMaxRetries,
+		RetryDelay: config.PushRetryDelay,
+		store:      s,
+		messenger:  m,
+		location:   location,
+		now:        time.Now,
+	}
+}
+
+// Result counts the push notification deliveries by outcome
+type Result struct {
+	// Sent is number of tokens the notification delivered to
+	Sent int `json:"sent"`
+	// Pruned is number of unregistered or invalid tokens, removed from the user
+	Pruned int `json:"pruned"`
+	// Failed is number of tokens still failing after retries
+	Failed int `json:"failed"`
+	// Errors is number of pruned and failed tokens by the last failure reason
+	Errors map[string]int `json:"errors,omitempty"`
 }
 
 // Handle handles the request
@@ -32,96 +73,385 @@ func (h *Handler) Handle() func(*fiber.Ctx) {
 	return func(c *fiber.Ctx) {
 		msg, ok := c.Locals(pubsub.LocalsKey).(*pubsub.Message)
 		if !ok {
-			c.Next(errors.New("unable to retrieve PubSub message from c.Locals"))
+			c.Next(fault.Permanent(errors.New("unable to retrieve PubSub message from c.Locals")))
 			return
 		}
-
-		// validate payload
-		var payload types.PushNotificationPayload
-		if err := json.Unmarshal(msg.Message.Data, &payload); err != nil {
+		if err := h.Process(context.Background(), transport.FromPush(c, msg)); err != nil {
 			c.Next(err)
 			return
 		}
-		if payload.Title == "" || payload.Body == "" || payload.UserID == "" {
-			c.Next(errors.New("Invalid message payload: missing user_id, title or body"))
-			return
+		c.SendStatus(http.StatusOK)
+	}
+}
+
+// Process sends push notification specified by the message, duplicate message is ignored
+func (h *Handler) Process(ctx context.Context, msg *transport.Message) error {
+	// validate payload
+	var payload types.PushNotificationPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return err
+	}
+	if payload.Title == "" || payload.Body == "" || (payload.UserID == "" && payload.Topic == "") {
+		return fault.Permanent(errors.New("Invalid message payload: missing user_id or topic, title or body"))
+	}
+	// FCM rejects the whole message as invalid argument, which would prune all user tokens
+	if payload.Webpush != nil && payload.Webpush.Link != "" && !strings.HasPrefix(payload.Webpush.Link, "https://") {
+		return fault.Permanent(errors.New("Invalid message payload: webpush link must be HTTPS"))
+	}
+
+	// without deliveryAttempt every attempt is recorded, record() keeps only the first one
+	last := msg.DeliveryAttempt == 0 || msg.DeliveryAttempt >= config.MaxDeliveryAttempts
+	err := store.Once(ctx, h.store, "push:"+msg.ID, config.DedupTTL, func() error {
+		_, err := h.dispatch(ctx, msg.ID, &payload, last)
+		return err
+	})
+	if err == store.ErrDuplicate {
+		log.Println("[DUPLICATE] push message", msg.ID)
+		return nil
+	}
+	return err
+}
+
+// dispatch sends the notification to the topic, or to the user when allowed by user's preferences.
+// Allowed notifications are added to user's inbox, except digests whose entries are already there.
+// Entry notifications are buffered for user's digest, notifications in user's quiet hours are dropped or deferred.
+// Buffered and deferred notifications are held as pending notification with given id, returns true when the notification is held.
+// Failures that will be retried are recorded in delivery stats only on the last attempt.
+func (h *Handler) dispatch(ctx context.Context, id string, payload *types.PushNotificationPayload, last bool) (bool, error) {
+	if payload.Topic != "" {
+		return false, h.pushTopic(ctx, id, payload, last)
+	}
+
+	user, err := h.store.GetUser(ctx, payload.UserID)
+	if err != nil {
+		return false, err
+	}
+	prefs := user.Preferences
+	now := h.now()
+
+	if (payload.Category != "" && !prefs.AllowsCategory(payload.Category)) ||
+		(payload.Data["data_type"] == "response" && !prefs.AllowsReplies()) {
+		log.Printf("[SUPPRESSED] user %s: disabled by preferences\n", payload.UserID)
+		return false, nil
+	}
+
+	// inbox keeps notifications suppressed by quiet hours and rate limit, digest entries are kept individually
+	if payload.Data["data_type"] != "digest" {
+		if err := h.store.AddNotification(ctx, payload.UserID, &types.Notification{
+			ID:        id,
+			Title:     payload.Title,
+			Body:      payload.Body,
+			Image:     payload.Image,
+			Data:      payload.Data,
+			CreatedAt: now,
+		}); err != nil {
+			return false, err
 		}
+	}
 
-		ctx := context.Background()
+	if prefs != nil {
+		if prefs.Digest != nil && payload.Category != "" && payload.Data["data_type"] == "entry" {
+			due := prefs.Digest.Next(now, h.location)
+			return true, h.store.SavePendingNotification(ctx, &types.PendingNotification{
+				ID:        id,
+				UserID:    payload.UserID,
+				DigestKey: digestKey(payload.UserID, payload.Category),
+				Payload:   *payload,
+				DueAt:     due,
+				CreatedAt: now,
+			})
+		}
 
-		// init clients
-		if err := h.google.InitFirestore(ctx); err != nil {
-			c.Next(err)
-			return
+		if until, quiet := prefs.QuietHours.QuietUntil(now, h.location); quiet {
+			if !prefs.QuietHours.Defer {
+				log.Printf("[SUPPRESSED] user %s: quiet hours\n", payload.UserID)
+				return false, nil
+			}
+			log.Printf("[DEFERRED] user %s: quiet hours until %s\n", payload.UserID, until)
+			return true, h.store.SavePendingNotification(ctx, &types.PendingNotification{
+				ID:        id,
+				UserID:    payload.UserID,
+				Payload:   *payload,
+				DueAt:     until,
+				CreatedAt: now,
+			})
 		}
-		if err := h.google.InitMessaging(ctx); err != nil {
-			c.Next(err)
-			return
+
+		if prefs.MaxPerHour > 0 {
+			count, err := h.store.IncrementCounter(ctx, "push_rate_"+payload.UserID, time.Hour)
+			if err != nil {
+				return false, err
+			}
+			if count > prefs.MaxPerHour {
+				log.Printf("[SUPPRESSED] user %s: more than %d notifications per hour\n", payload.UserID, prefs.MaxPerHour)
+				return false, nil
+			}
 		}
+	}
+	return false, h.push(ctx, id, user, payload, last)
+}
 
-		// preparing to push
-		doc, err := h.google.Firestore.Collection("users").Doc(payload.UserID).Get(ctx)
-		if err != nil {
-			c.Next(err)
-			return
+// push sends the notification to all user's devices
+func (h *Handler) push(ctx context.Context, id string, user *types.User, payload *types.PushNotificationPayload, last bool) error {
+	tokensMap := user.FCMTokens
+	if len(tokensMap) == 0 {
+		// expected for users who never allowed notifications, nothing to retry nor to park
+		log.Printf("[SKIPPED] user %s: no FCM tokens\n", payload.UserID)
+		h.record(ctx, id, payload, &Result{Errors: map[string]int{"no-tokens": 1}})
+		return nil
+	}
+
+	tokens := make([]string, 0, len(tokensMap))
+	for token := range tokensMap {
+		tokens = append(tokens, token)
+	}
+	m := newMessage(payload)
+	message := &messaging.MulticastMessage{
+		Data:         m.Data,
+		Notification: m.Notification,
+		Android:      m.Android,
+		APNS:         m.APNS,
+		Webpush:      m.Webpush,
+	}
+	result, pruned := h.send(ctx, message, tokens)
+	log.Printf("[PUSH] user %s: sent=%d pruned=%d failed=%d\n", payload.UserID, result.Sent, result.Pruned, result.Failed)
+	// nothing delivered yet, safe to let Pub/Sub redeliver the message
+	retry := result.Sent == 0 && result.Failed > 0
+	if !retry || last {
+		h.record(ctx, id, payload, result)
+	}
+
+	if len(pruned) > 0 {
+		for _, token := range pruned {
+			delete(tokensMap, token)
 		}
-		user := doc.Data()
-		tokens, ok := user["fcm_tokens"]
-		if !ok {
-			c.Next(fmt.Errorf("User %v doesn't have FCM tokens", payload.UserID))
-			return
+		// store back the remaining tokens to user document.
+		// notifications are already sent, don't fail the message so it won't be sent twice.
+		if err := h.store.SetUserTokens(ctx, payload.UserID, tokensMap); err != nil {
+			log.Println("[ERROR] Error saving fcm_tokens back to user doc:", err)
 		}
-		tokensMap := tokens.(map[string]interface{}) // convert to map
-		if len(tokensMap) == 0 {
-			c.Next(fmt.Errorf("User %v doesn't have FCM tokens", payload.UserID))
-			return
+	}
+
+	if retry {
+		return fault.Retryable(fmt.Errorf("notification to user %v failed on all %d tokens", payload.UserID, result.Failed))
+	}
+	return nil
+}
+
+// pushTopic sends the notification to all devices subscribed to FCM topic,
+// recorded as delivery to single token.
+func (h *Handler) pushTopic(ctx context.Context, id string, payload *types.PushNotificationPayload, last bool) error {
+	message := newMessage(payload)
+	message.Topic = payload.Topic
+
+	fcmID, err := h.messenger.Send(ctx, message)
+	if err != nil {
+		permanent := messaging.IsInvalidArgument(err)
+		if permanent || last {
+			h.record(ctx, id, payload, &Result{Failed: 1, Errors: map[string]int{reason(err): 1}})
 		}
+		if permanent {
+			return fault.Permanent(err)
+		}
+		return err
+	}
+	log.Printf("[PUSH] topic %s: sent %s\n", payload.Topic, fcmID)
+	h.record(ctx, id, payload, &Result{Sent: 1})
+	return nil
+}
+
+// record saves the delivery record of notification with given id, failing to record doesn't fail the notification
+func (h *Handler) record(ctx context.Context, id string, payload *types.PushNotificationPayload, result *Result) {
+	now := h.now()
+	d := &types.Delivery{
+		ID:         id,
+		UserID:     payload.UserID,
+		Topic:      payload.Topic,
+		DataType:   payload.Data["data_type"],
+		Category:   first(payload.Category, payload.Data["category_id"]),
+		EntryID:    payload.Data["entry_id"],
+		ResponseID: payload.Data["response_id"],
+		Tokens:     result.Sent + result.Pruned + result.Failed,
+		Sent:       result.Sent,
+		Pruned:     result.Pruned,
+		Failed:     result.Failed,
+		Errors:     result.Errors,
+		CreatedAt:  now,
+	}
+	// redelivered message is counted once in delivery stats
+	err := store.Once(ctx, h.store, "delivery:"+id, config.DedupTTL, func() error {
+		return h.store.RecordDelivery(ctx, now.In(h.location).Format("2006-01-02"), d)
+	})
+	if err != nil && err != store.ErrDuplicate {
+		log.Println("[ERROR] Error saving delivery record:", err)
+	}
+}
+
+// reason returns short failure reason of FCM error
+func reason(err error) string {
+	switch {
+	case messaging.IsRegistrationTokenNotRegistered(err):
+		return "unregistered"
+	case messaging.IsInvalidArgument(err):
+		return "invalid-argument"
+	case messaging.IsMessageRateExceeded(err):
+		return "rate-exceeded"
+	case messaging.IsServerUnavailable(err):
+		return "unavailable"
+	case messaging.IsInternal(err):
+		return "internal"
+	default:
+		return "unknown"
+	}
+}
+
+// newMessage returns the notification message with Android, iOS and web specific configs
+func newMessage(payload *types.PushNotificationPayload) *messaging.Message {
+	// Android & iOS
+	notification := &messaging.Notification{
+		Title: payload.Title,
+		Body:  payload.Body,
+	}
+
+	// -- Android sepcific config
+	androidNotification := &messaging.AndroidNotification{
+		// Icon:     "https://raw.githubusercontent.com/apps4bali/gatrabali-app/master/assets/images/icon.png",
+		ImageURL: payload.Image,
+		Color:    "#4CB050",
+	}
+	androidConfig := messaging.AndroidConfig{
+		Notification: androidNotification,
+	}
+	if payload.CollapseKey != "" {
+		androidConfig.CollapseKey = payload.CollapseKey
+	}
+	// -- End Android sepcific config
+
+	// -- iOS specific config
+	apnsOptions := payload.APNS
+	if apnsOptions == nil {
+		apnsOptions = &types.APNSOptions{}
+	}
+	aps := &messaging.Aps{
+		MutableContent: apnsOptions.MutableContent,
+		ThreadID:       apnsOptions.ThreadID,
+		Badge:          apnsOptions.Badge,
+	}
+	apnsConfig := messaging.APNSConfig{Payload: &messaging.APNSPayload{Aps: aps}}
+	if image := first(apnsOptions.Image, payload.Image); image != "" {
+		aps.MutableContent = true // image is downloaded by notification service extension
+		apnsConfig.FCMOptions = &messaging.APNSFCMOptions{ImageURL: image}
+	}
+	if payload.CollapseKey != "" {
+		apnsConfig.Headers = map[string]string{"apns-collapse-id": payload.CollapseKey}
+	}
+	// -- End iOS specific config
 
-		// Android & iOS
-		notification := &messaging.Notification{
+	// -- Web specific config
+	webpushOptions := payload.Webpush
+	if webpushOptions == nil {
+		webpushOptions = &types.WebpushOptions{}
+	}
+	webpushConfig := messaging.WebpushConfig{
+		Notification: &messaging.WebpushNotification{
 			Title: payload.Title,
 			Body:  payload.Body,
-		}
+			Icon:  webpushOptions.Icon,
+			Image: first(webpushOptions.Image, payload.Image),
+		},
+	}
+	if webpushOptions.Link != "" {
+		webpushConfig.FcmOptions = &messaging.WebpushFcmOptions{Link: webpushOptions.Link}
+	}
+	// -- End Web specific config
 
-		// -- Android sepcific config
-		androidNotification := &messaging.AndroidNotification{
-			// Icon:     "https://raw.githubusercontent.com/apps4bali/gatrabali-app/master/assets/images/icon.png",
-			ImageURL: payload.Image,
-			Color:    "#4CB050",
-		}
-		androidConfig := messaging.AndroidConfig{
-			Notification: androidNotification,
+	return &messaging.Message{
+		Data:         payload.Data,
+		Notification: notification,
+		Android:      &androidConfig,
+		APNS:         &apnsConfig,
+		Webpush:      &webpushConfig,
+	}
+}
+
+// first returns the first non empty value
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
 		}
-		if payload.CollapseKey != "" {
-			androidConfig.CollapseKey = payload.CollapseKey
+	}
+	return ""
+}
+
+// send sends message to tokens in batches of maxMulticastTokens,
+// tokens failed with retryable errors are retried up to h.MaxRetries.
+// Returns the delivery counts and tokens to be removed from the user.
+func (h *Handler) send(ctx context.Context, message *messaging.MulticastMessage, tokens []string) (*Result, []string) {
+	result := &Result{Errors: make(map[string]int)}
+	var pruned []string
+	reasons := make(map[string]string) // last failure reason of failed tokens
+	delay := h.RetryDelay
+
+	for retry := 0; len(tokens) > 0; retry++ {
+		if retry > 0 {
+			if retry > h.MaxRetries || !sleep(ctx, delay) {
+				break
+			}
+			delay *= 2
 		}
-		// -- End Android sepcific config
-
-		// loop through tokens and send the notification
-		for token := range tokensMap {
-			message := &messaging.Message{
-				Data:         payload.Data,
-				Notification: notification,
-				Token:        token,
-				Android:      &androidConfig,
+
+		var failed []string
+		for start := 0; start < len(tokens); start += maxMulticastTokens {
+			end := start + maxMulticastTokens
+			if end > len(tokens) {
+				end = len(tokens)
 			}
+			batch := tokens[start:end]
 
-			if _, err := h.google.Messaging.Send(ctx, message); err != nil {
-				// if error, delete token
-				log.Println("Notification not sent:", err)
-				delete(tokensMap, token)
+			m := *message
+			m.Tokens = batch
+			br, err := h.messenger.SendMulticast(ctx, &m)
+			if err != nil {
+				log.Println("Notification batch not sent:", err)
+				for _, token := range batch {
+					reasons[token] = reason(err)
+				}
+				failed = append(failed, batch...)
+				continue
+			}
+			for i, r := range br.Responses {
+				switch {
+				case r.Success:
+					result.Sent++
+				case messaging.IsRegistrationTokenNotRegistered(r.Error) || messaging.IsInvalidArgument(r.Error):
+					pruned = append(pruned, batch[i])
+					result.Errors[reason(r.Error)]++
+				default:
+					// quota, unavailable or internal errors
+					log.Println("Notification not sent:", r.Error)
+					reasons[batch[i]] = reason(r.Error)
+					failed = append(failed, batch[i])
+				}
 			}
 		}
+		tokens = failed
+	}
 
-		// store back the remaining tokens to user document
-		if _, err = h.google.Firestore.
-			Collection("users").
-			Doc(payload.UserID).
-			Update(ctx, []firestore.Update{{Path: "fcm_tokens", Value: tokensMap}}); err != nil {
+	for _, token := range tokens {
+		result.Errors[reasons[token]]++
+	}
+	result.Pruned = len(pruned)
+	result.Failed = len(tokens)
+	return result, pruned
+}
 
-			c.Next(fmt.Errorf("Error saving fcm_tokens back to user doc: %v", err))
-			return
-		}
-		c.SendStatus(http.StatusOK)
+// sleep waits for d, returns false when ctx is done
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
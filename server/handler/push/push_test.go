@@ -0,0 +1,326 @@
+package push
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/messaging"
+	pubs "github.com/fiberweb/pubsub"
+	"github.com/gofiber/fiber"
+
+	"server/common/fault"
+	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+)
+
+// flakyMessenger fails every token on the first attempt
+type flakyMessenger struct {
+	*service.Local
+	calls    int
+	attempts map[string]int
+	last     *messaging.MulticastMessage
+}
+
+func (m *flakyMessenger) SendMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+	m.calls++
+	m.last = message
+	br := &messaging.BatchResponse{}
+	for _, token := range message.Tokens {
+		m.attempts[token]++
+		if m.attempts[token] == 1 {
+			br.Responses = append(br.Responses, &messaging.SendResponse{Error: errors.New("unavailable")})
+			br.FailureCount++
+			continue
+		}
+		br.Responses = append(br.Responses, &messaging.SendResponse{Success: true})
+		br.SuccessCount++
+	}
+	return br, nil
+}
+
+// docIDStore fails like Firestore when a document ID contains "/"
+type docIDStore struct {
+	*store.Memory
+	t *testing.T
+}
+
+func (s *docIDStore) check(id string) error {
+	if strings.Contains(id, "/") {
+		s.t.Errorf("document ID %q contains \"/\"", id)
+		return fault.Retryable(fmt.Errorf("invalid document ID %q", id))
+	}
+	return nil
+}
+
+func (s *docIDStore) ClaimMessage(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	if err := s.check(id); err != nil {
+		return false, err
+	}
+	return s.Memory.ClaimMessage(ctx, id, ttl)
+}
+
+func (s *docIDStore) IncrementAttempts(ctx context.Context, id string, ttl time.Duration) (int, error) {
+	if err := s.check(id); err != nil {
+		return 0, err
+	}
+	return s.Memory.IncrementAttempts(ctx, id, ttl)
+}
+
+func (s *docIDStore) IncrementCounter(ctx context.Context, key string, window time.Duration) (int, error) {
+	if err := s.check(key); err != nil {
+		return 0, err
+	}
+	return s.Memory.IncrementCounter(ctx, key, window)
+}
+
+func (s *docIDStore) SavePendingNotification(ctx context.Context, n *types.PendingNotification) error {
+	if err := s.check(n.ID); err != nil {
+		return err
+	}
+	return s.Memory.SavePendingNotification(ctx, n)
+}
+
+func (s *docIDStore) AddNotification(ctx context.Context, userID string, n *types.Notification) error {
+	if err := s.check(n.ID); err != nil {
+		return err
+	}
+	return s.Memory.AddNotification(ctx, userID, n)
+}
+
+func (s *docIDStore) RecordDelivery(ctx context.Context, day string, d *types.Delivery) error {
+	if err := s.check(d.ID); err != nil {
+		return err
+	}
+	return s.Memory.RecordDelivery(ctx, day, d)
+}
+
+func TestPushBatches(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+
+	tokens := make(map[string]interface{})
+	for i := 0; i < 600; i++ {
+		tokens[fmt.Sprintf("token-%d", i)] = true
+	}
+	db.SaveUser(ctx, &types.User{ID: "u1", FCMTokens: tokens})
+
+	m := &flakyMessenger{Local: service.NewLocal(), attempts: make(map[string]int)}
+	h := New(db, m)
+	h.RetryDelay = 0
+
+	if _, err := h.dispatch(ctx, "1", &types.PushNotificationPayload{UserID: "u1", Title: "t", Body: "b"}, false); err != nil {
+		t.Fatal(err)
+	}
+	// 2 batches, then 2 batches of retries
+	if m.calls != 4 {
+		t.Errorf("SendMulticast called %d times, want 4", m.calls)
+	}
+
+	// no retries, every token failed
+	m = &flakyMessenger{Local: service.NewLocal(), attempts: make(map[string]int)}
+	h = New(db, m)
+	h.MaxRetries = 0
+	_, err := h.dispatch(ctx, "2", &types.PushNotificationPayload{UserID: "u1", Title: "t", Body: "b"}, false)
+	if err == nil || !fault.IsRetryable(err) {
+		t.Errorf("dispatch() = %v, want retryable error", err)
+	}
+	if user, _ := db.GetUser(ctx, "u1"); len(user.FCMTokens) != 600 {
+		t.Error("Tokens with retryable errors should not be removed")
+	}
+	stats, _ := db.ListDeliveryStats(ctx, "2000-01-01", "2100-01-01")
+	if len(stats) != 1 || stats[0].Notifications != 1 || stats[0].Failed != 0 {
+		t.Errorf("Failure to be retried should not be recorded %+v", stats)
+	}
+
+	// the last attempt is recorded once, even when redelivered
+	for i := 0; i < 2; i++ {
+		m.attempts = make(map[string]int)
+		h.dispatch(ctx, "2", &types.PushNotificationPayload{UserID: "u1", Title: "t", Body: "b"}, true)
+	}
+	stats, _ = db.ListDeliveryStats(ctx, "2000-01-01", "2100-01-01")
+	if len(stats) != 1 || stats[0].Notifications != 2 || stats[0].Sent != 600 || stats[0].Failed != 600 {
+		t.Errorf("Unexpected delivery stats %+v", stats)
+	}
+	deliveries, _ := db.ListDeliveries(ctx, store.DeliveryQuery{UserID: "u1"})
+	if len(deliveries) != 2 || deliveries[0].Errors["unknown"]+deliveries[1].Errors["unknown"] != 600 {
+		t.Errorf("Unexpected delivery records %+v", deliveries)
+	}
+}
+
+func TestPushRedelivery(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+	db.SaveUser(ctx, &types.User{ID: "u1", FCMTokens: map[string]interface{}{"token": true}})
+
+	m := &flakyMessenger{Local: service.NewLocal(), attempts: make(map[string]int)}
+	h := New(db, m)
+	h.MaxRetries = 0
+
+	app := fiber.New()
+	app.Use(pubs.New(pubs.Config{Debug: false}))
+	// like the attempt counted by the delivery attempt middleware, when PubSub doesn't send it
+	app.Use(func(c *fiber.Ctx) {
+		if attempt, err := strconv.Atoi(c.Get("X-Attempt")); err == nil {
+			c.Locals(transport.AttemptKey, attempt)
+		}
+		c.Next()
+	})
+	app.Post("/", h.Handle())
+	deliver := func(envelope string, counted string) int {
+		data := base64.StdEncoding.EncodeToString([]byte(`{"user_id":"u1","title":"t","body":"b"}`))
+		body := fmt.Sprintf(`{"message":{"message_id":"1","data":"%s"},"subscription":"s"%s}`, data, envelope)
+		req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		req.Header.Set("X-Attempt", counted)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// the first attempt fails on every token and is redelivered
+	if status := deliver(`,"deliveryAttempt":1`, ""); status == http.StatusOK {
+		t.Error("Failed attempt should not be acked")
+	}
+	if stats, _ := db.ListDeliveryStats(ctx, "2000-01-01", "2100-01-01"); len(stats) != 0 {
+		t.Errorf("Failure to be retried should not be recorded %+v", stats)
+	}
+
+	if status := deliver("", "2"); status != http.StatusOK {
+		t.Errorf("Redelivered attempt status = %d, want 200", status)
+	}
+	stats, _ := db.ListDeliveryStats(ctx, "2000-01-01", "2100-01-01")
+	if len(stats) != 1 || stats[0].Notifications != 1 || stats[0].Sent != 1 || stats[0].Failed != 0 {
+		t.Errorf("Unexpected delivery stats %+v", stats)
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	badge := 3
+	m := newMessage(&types.PushNotificationPayload{
+		Title:   "t",
+		Body:    "b",
+		Image:   "https://example.com/a.png",
+		APNS:    &types.APNSOptions{ThreadID: "entry_1", Badge: &badge},
+		Webpush: &types.WebpushOptions{Link: "https://example.com/entry/1"},
+	})
+
+	aps := m.APNS.Payload.Aps
+	if !aps.MutableContent || aps.ThreadID != "entry_1" || *aps.Badge != 3 {
+		t.Errorf("Unexpected aps: %+v", aps)
+	}
+	if m.APNS.FCMOptions.ImageURL != "https://example.com/a.png" || m.Webpush.Notification.Image != "https://example.com/a.png" {
+		t.Error("Payload image should be used by iOS and web")
+	}
+	if m.Webpush.FcmOptions.Link != "https://example.com/entry/1" {
+		t.Errorf("Unexpected webpush link: %+v", m.Webpush.FcmOptions)
+	}
+
+	// invalid link rejected before sending
+	db := store.NewMemory()
+	h := New(db, service.NewLocal())
+	err := h.Process(context.Background(), &transport.Message{ID: "1", Data: []byte(`{"user_id":"u1","title":"t","body":"b","webpush":{"link":"http://example.com"}}`)})
+	if err == nil || fault.IsRetryable(err) {
+		t.Errorf("Process() = %v, want permanent error", err)
+	}
+
+	// user without tokens is acked, not dead-lettered
+	db.SaveUser(context.Background(), &types.User{ID: "u2"})
+	if err := h.Process(context.Background(), &transport.Message{ID: "2", Data: []byte(`{"user_id":"u2","title":"t","body":"b"}`)}); err != nil {
+		t.Errorf("Process() = %v, want nil", err)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+	db.SaveUser(ctx, &types.User{
+		ID:        "u1",
+		FCMTokens: map[string]interface{}{"token": true},
+		Preferences: &types.NotificationPreferences{
+			QuietHours: &types.QuietHours{Start: "22:00", End: "06:00", Timezone: "UTC", Defer: true},
+			MaxPerHour: 1,
+		},
+	})
+
+	m := &flakyMessenger{Local: service.NewLocal(), attempts: map[string]int{"token": 1}}
+	h := New(db, m)
+	h.now = func() time.Time { return time.Date(2020, 1, 10, 23, 0, 0, 0, time.UTC) }
+
+	payload := &types.PushNotificationPayload{UserID: "u1", Title: "t", Body: "b"}
+	if deferred, err := h.dispatch(ctx, "1", payload, false); !deferred || err != nil {
+		t.Fatalf("dispatch() = %v, %v, want deferred", deferred, err)
+	}
+	if flushed, _ := h.FlushPending(ctx); flushed != 0 || m.calls != 0 {
+		t.Error("Notification should not be sent during quiet hours")
+	}
+
+	h.now = func() time.Time { return time.Date(2020, 1, 11, 6, 0, 0, 0, time.UTC) }
+	db.SavePendingNotification(ctx, &types.PendingNotification{ID: "2", UserID: "u1", Payload: *payload, DueAt: h.now()})
+	if flushed, err := h.FlushPending(ctx); flushed != 2 || err != nil {
+		t.Errorf("FlushPending() = %d, %v, want 2", flushed, err)
+	}
+	// the second one is over max per hour
+	if m.calls != 1 {
+		t.Errorf("SendMulticast called %d times, want 1", m.calls)
+	}
+	// suppressed notifications are still in the inbox
+	if count, _ := db.CountUnreadNotifications(ctx, "u1"); count != 2 {
+		t.Errorf("CountUnreadNotifications() = %d, want 2", count)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	ctx := context.Background()
+	db := &docIDStore{Memory: store.NewMemory(), t: t}
+	db.SaveUser(ctx, &types.User{
+		ID:        "u1",
+		FCMTokens: map[string]interface{}{"token": true},
+		Preferences: &types.NotificationPreferences{
+			Digest: &types.Digest{Timezone: "UTC"},
+			// the digest is deferred once more
+			QuietHours: &types.QuietHours{Start: "09:00", End: "09:30", Timezone: "UTC", Defer: true},
+		},
+	})
+
+	m := &flakyMessenger{Local: service.NewLocal(), attempts: map[string]int{"token": 1}}
+	h := New(db, m)
+	h.now = func() time.Time { return time.Date(2020, 1, 10, 8, 30, 0, 0, time.UTC) }
+
+	for i, title := range []string{"Berita 1", "Berita 2"} {
+		payload := &types.PushNotificationPayload{
+			UserID:   "u1",
+			Category: "2",
+			Title:    "Badung",
+			Body:     title,
+			Data:     map[string]string{"data_type": "entry", "entry_title": title, "category_title": "Badung"},
+		}
+		if held, err := h.dispatch(ctx, fmt.Sprint(i), payload, false); !held || err != nil {
+			t.Fatalf("dispatch() = %v, %v, want held", held, err)
+		}
+	}
+
+	h.now = func() time.Time { return time.Date(2020, 1, 10, 9, 0, 0, 0, time.UTC) }
+	if flushed, err := h.FlushPending(ctx); flushed != 2 || err != nil || m.calls != 0 {
+		t.Fatalf("FlushPending() = %d, %v, want 2 deferred by quiet hours", flushed, err)
+	}
+
+	h.now = func() time.Time { return time.Date(2020, 1, 10, 9, 30, 0, 0, time.UTC) }
+	if flushed, err := h.FlushPending(ctx); flushed != 1 || err != nil {
+		t.Errorf("FlushPending() = %d, %v, want 1", flushed, err)
+	}
+	if m.calls != 1 || m.last.Notification.Title != "2 berita baru di Badung" || m.last.Data["count"] != "2" {
+		t.Errorf("Unexpected digest %+v", m.last)
+	}
+}
@@ -6,75 +6,99 @@ import (
 	"strconv"
 
 	"server/common/constant"
+	"server/common/fault"
+	"server/common/store"
 	"server/common/types"
 )
 
-// storeCategories calls Miniflux categories API and store the objects to Firestore
+// storeCategories calls Miniflux categories API and store the objects
 func (h *Handler) storeCategories(ctx context.Context, payload *types.SyncPayload) error {
 	if *payload.Op == constant.OpWrite {
-		categories, err := getCategories(ctx)
+		categories, err := h.getCategories(ctx)
 		if err != nil {
-			return fmt.Errorf("storeCategories failed: %s", err)
+			return fmt.Errorf("storeCategories failed: %w", err)
 		}
-
-		// write in batch
-		batch := h.google.Firestore.Batch()
-		for _, cat := range *categories {
-			docRef := h.google.Firestore.Collection(constant.Categories).Doc(strconv.FormatInt(cat.ID, 10))
-			batch.Set(docRef, cat)
-		}
-		_, err = batch.Commit(ctx)
-		return err
+		return h.store.SaveCategories(ctx, categories)
 
 	} else if *payload.Op == constant.OpDelete {
-		_, err := h.google.Firestore.Collection(constant.Categories).Doc(strconv.FormatInt(*payload.ID, 10)).Delete(ctx)
-		return err
+		return h.store.DeleteCategory(ctx, *payload.ID)
 	}
-	return fmt.Errorf("Invalid operation for storeCategories: %v", *payload.Op)
+	return fault.Permanentf("Invalid operation for storeCategories: %v", *payload.Op)
 }
 
-// storeFeed calls Miniflux feeds API and store the object to Firestore
+// storeFeed calls Miniflux feeds API and store the object
 func (h *Handler) storeFeed(ctx context.Context, payload *types.SyncPayload) error {
 	if *payload.Op == constant.OpWrite {
-		feed, err := getFeed(ctx, *payload.ID)
+		feed, err := h.getFeed(ctx, *payload.ID)
 		if err != nil {
-			return fmt.Errorf("storeFeed failed: %s", err)
+			return fmt.Errorf("storeFeed failed: %w", err)
 		}
-		_, err = h.google.Firestore.Collection(constant.Feeds).Doc(strconv.FormatInt(*payload.ID, 10)).Set(ctx, feed)
-		return err
+		return h.store.SaveFeed(ctx, feed)
 
 	} else if *payload.Op == constant.OpDelete {
-		_, err := h.google.Firestore.Collection(constant.Feeds).Doc(strconv.FormatInt(*payload.ID, 10)).Delete(ctx)
-		return err
+		return h.store.DeleteFeed(ctx, *payload.ID)
 	}
-	return fmt.Errorf("Invalid operation for storeFeed: %v", *payload.Op)
+	return fault.Permanentf("Invalid operation for storeFeed: %v", *payload.Op)
 }
 
-// storeEntry calls Miniflux entries API and store the object to Firestore
+// storeEntry calls Miniflux entries API and store the object
 func (h *Handler) storeEntry(ctx context.Context, payload *types.SyncPayload) error {
 	if *payload.Op == constant.OpWrite {
-		entry, err := getEntry(ctx, *payload.ID)
+		entry, err := h.getEntry(ctx, *payload.ID)
 		if err != nil {
-			return fmt.Errorf("storeEntry failed: %s", err)
+			return fmt.Errorf("storeEntry failed: %w", err)
 		}
-		// if category `kriminal` or `baliunited` store so sparate collection
-		if entry.CategoryID == 11 {
-			entry.ID = entry.PublishedAt
-			_, err = h.google.Firestore.Collection(constant.Kriminal).Doc(strconv.FormatInt(entry.ID, 10)).Set(ctx, entry)
-		} else if entry.CategoryID == 12 {
-			entry.ID = entry.PublishedAt
-			_, err = h.google.Firestore.Collection(constant.BaliUnited).Doc(strconv.FormatInt(entry.ID, 10)).Set(ctx, entry)
-		} else if entry.CategoryID > 12 {
-			_, err = h.google.Firestore.Collection(constant.BaleBengong).Doc(strconv.FormatInt(*payload.ID, 10)).Set(ctx, entry)
-		} else {
-			_, err = h.google.Firestore.Collection(constant.Entries).Doc(strconv.FormatInt(*payload.ID, 10)).Set(ctx, entry)
+		collection, id := h.routes.Locate(entry, *payload.ID)
+		return h.saveEntry(ctx, *payload.ID, collection, id, entry)
+
+	} else if *payload.Op == constant.OpDelete {
+		ref, err := h.store.GetEntryRef(ctx, *payload.ID)
+		if err == store.ErrNotFound {
+			// stored before the index exists, can only be in the default collection
+			ref = &store.EntryRef{Collection: constant.Entries, ID: strconv.FormatInt(*payload.ID, 10)}
+		} else if err != nil {
+			return fmt.Errorf("storeEntry failed: %w", err)
 		}
+		return h.deleteEntry(ctx, *payload.ID, ref)
+	}
+	return fault.Permanentf("Invalid operation for storeEntry: %v", *payload.Op)
+}
+
+// saveEntry stores the entry and indexes its location by Miniflux ID
+func (h *Handler) saveEntry(ctx context.Context, minifluxID int64, collection, id string, entry *types.Entry) error {
+	if err := h.store.SaveEntry(ctx, collection, id, entry); err != nil {
 		return err
+	}
+	return h.store.SaveEntryRef(ctx, minifluxID, &store.EntryRef{Collection: collection, ID: id})
+}
 
-	} else if *payload.Op == constant.OpDelete {
-		// we don't support delete on separate collection for now eg. kriminal
-		_, err := h.google.Firestore.Collection(constant.Entries).Doc(strconv.FormatInt(*payload.ID, 10)).Delete(ctx)
+// deleteEntry deletes the entry together with its responses and index,
+// minifluxID is 0 when unknown. Entries of other collections may have the same ID (eg. kriminal and baliunited
+// are keyed by PublishedAt), only responses whose entry category is routed to the collection are deleted.
+// The entry counters live in the entry document so they're gone with it.
+func (h *Handler) deleteEntry(ctx context.Context, minifluxID int64, ref *store.EntryRef) error {
+	entryID, err := strconv.ParseInt(ref.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid entry ID %s/%s: %w", ref.Collection, ref.ID, err)
+	}
+	responses, err := h.store.ListResponses(ctx, store.ResponseQuery{EntryID: entryID})
+	if err != nil {
 		return err
 	}
-	return fmt.Errorf("Invalid operation for storeEntry: %v", *payload.Op)
+	var ids []string
+	for _, r := range responses {
+		if h.routes.Collection(r.EntryCategoryID, r.EntryFeedID) == ref.Collection {
+			ids = append(ids, r.ID)
+		}
+	}
+	if err := h.store.DeleteResponses(ctx, ids); err != nil {
+		return err
+	}
+	if err := h.store.DeleteEntry(ctx, ref.Collection, ref.ID); err != nil {
+		return err
+	}
+	if minifluxID == 0 {
+		return nil
+	}
+	return h.store.DeleteEntryRef(ctx, minifluxID)
 }
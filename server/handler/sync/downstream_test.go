@@ -0,0 +1,47 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"server/common/constant"
+	"server/common/miniflux"
+	"server/common/routing"
+	"server/common/store"
+	"server/common/types"
+)
+
+func TestStoreEntryDelete(t *testing.T) {
+	ctx := context.Background()
+
+	db := store.NewMemory()
+	h := New(db, miniflux.New(miniflux.Config{}), routing.Default())
+
+	// kriminal entry is keyed by PublishedAt
+	entry := &types.Entry{CategoryID: 11, PublishedAt: 5000}
+	collection, id := h.routes.Locate(entry, 101)
+	if err := h.saveEntry(ctx, 101, collection, id, entry); err != nil {
+		t.Fatal(err)
+	}
+	db.SaveResponse(ctx, &types.Response{ID: "c1", EntryID: 5000, EntryCategoryID: 11, Type: "COMMENT"})
+	db.SaveResponse(ctx, &types.Response{ID: "c2", EntryID: 5000, EntryCategoryID: 11, Type: "REACTION"})
+	db.SaveResponse(ctx, &types.Response{ID: "c3", EntryID: 1, EntryCategoryID: 11, Type: "COMMENT"})
+	// baliunited entry with the same PublishedAt
+	db.SaveResponse(ctx, &types.Response{ID: "c4", EntryID: 5000, EntryCategoryID: 12, Type: "COMMENT"})
+
+	op, typ, minifluxID := constant.OpDelete, constant.TypeEntry, int64(101)
+	if err := h.storeEntry(ctx, &types.SyncPayload{ID: &minifluxID, Type: &typ, Op: &op}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetEntry(ctx, constant.Kriminal, "5000"); err != store.ErrNotFound {
+		t.Error("Entry not deleted")
+	}
+	if _, err := db.GetEntryRef(ctx, 101); err != store.ErrNotFound {
+		t.Error("Entry index not deleted")
+	}
+	responses, _ := db.ListResponses(ctx, store.ResponseQuery{})
+	if len(responses) != 2 || responses[0].ID != "c3" || responses[1].ID != "c4" {
+		t.Error("Entry responses not deleted:", responses)
+	}
+}
@@ -0,0 +1,405 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiberweb/pubsub"
+	"github.com/gofiber/fiber"
+
+	"server/common/fault"
+	"server/common/miniflux"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+	"server/config"
+)
+
+// reconcilePageSize is number of Miniflux entries and stored entries fetched per page
+const reconcilePageSize = 100
+
+// ReconcileOptions is the options of full reconciliation
+type ReconcileOptions struct {
+	Since  time.Time // only entries published after this time are reconciled
+	DryRun bool      // only report the differences, don't write anything
+	Rate   int       // max Miniflux calls and store writes per second, 0 means unlimited
+}
+
+// Diff is the list of created, updated and deleted document IDs
+type Diff struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Deleted []string `json:"deleted"`
+}
+
+// Report is the result of reconciliation
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	Since      time.Time `json:"since"`
+	Categories Diff      `json:"categories"`
+	Feeds      Diff      `json:"feeds"`
+	Entries    Diff      `json:"entries"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+func (r *Report) addError(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// limiter limits number of operations per second
+type limiter struct {
+	ticker *time.Ticker
+}
+
+func newLimiter(rate int) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+	return &limiter{ticker: time.NewTicker(time.Second / time.Duration(rate))}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	if l.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
+
+// Reconcile pages through Miniflux categories, feeds and entries published since opts.Since,
+// compares them with the stored documents and creates, updates or deletes documents to converge.
+func (h *Handler) Reconcile(ctx context.Context, opts ReconcileOptions) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun, Since: opts.Since}
+	lim := newLimiter(opts.Rate)
+	defer lim.stop()
+
+	if err := h.reconcileCategories(ctx, lim, opts, report); err != nil {
+		return report, fmt.Errorf("reconcile categories failed: %w", err)
+	}
+	if err := h.reconcileFeeds(ctx, lim, opts, report); err != nil {
+		return report, fmt.Errorf("reconcile feeds failed: %w", err)
+	}
+	if err := h.reconcileEntries(ctx, lim, opts, report); err != nil {
+		return report, fmt.Errorf("reconcile entries failed: %w", err)
+	}
+	return report, nil
+}
+
+func (h *Handler) reconcileCategories(ctx context.Context, lim *limiter, opts ReconcileOptions, report *Report) error {
+	if err := lim.wait(ctx); err != nil {
+		return err
+	}
+	upstream, err := h.getCategories(ctx)
+	if err != nil {
+		return err
+	}
+	stored, err := h.store.ListCategories(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[int64]types.Category)
+	for _, cat := range stored {
+		current[cat.ID] = cat
+	}
+
+	var changed []types.Category
+	seen := make(map[int64]bool)
+	for _, cat := range upstream {
+		seen[cat.ID] = true
+		old, ok := current[cat.ID]
+		if !ok {
+			report.Categories.Created = append(report.Categories.Created, strconv.FormatInt(cat.ID, 10))
+		} else if old.Title != cat.Title {
+			report.Categories.Updated = append(report.Categories.Updated, strconv.FormatInt(cat.ID, 10))
+		} else {
+			continue
+		}
+		changed = append(changed, cat)
+	}
+	if len(changed) > 0 && !opts.DryRun {
+		if err := lim.wait(ctx); err != nil {
+			return err
+		}
+		if err := h.store.SaveCategories(ctx, changed); err != nil {
+			return err
+		}
+	}
+
+	// categories that don't come from Miniflux (eg. "balebengong") don't have numeric ID, leave them.
+	for _, cat := range stored {
+		if cat.ID <= 0 || seen[cat.ID] {
+			continue
+		}
+		report.Categories.Deleted = append(report.Categories.Deleted, strconv.FormatInt(cat.ID, 10))
+		if opts.DryRun {
+			continue
+		}
+		if err := lim.wait(ctx); err != nil {
+			return err
+		}
+		if err := h.store.DeleteCategory(ctx, cat.ID); err != nil {
+			report.addError("delete category %v: %s", cat.ID, err)
+		}
+	}
+	return nil
+}
+
+// feedChanged reports whether stored feed differs from Miniflux feed
+func feedChanged(old, new types.Feed) bool {
+	return old.Title != new.Title ||
+		old.FeedURL != new.FeedURL ||
+		old.SiteURL != new.SiteURL ||
+		old.Category != new.Category ||
+		old.UserID != new.UserID
+}
+
+func (h *Handler) reconcileFeeds(ctx context.Context, lim *limiter, opts ReconcileOptions, report *Report) error {
+	if err := lim.wait(ctx); err != nil {
+		return err
+	}
+	upstream, err := h.miniflux.Feeds(ctx)
+	if err != nil {
+		return err
+	}
+	stored, err := h.store.ListFeeds(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[int64]types.Feed)
+	for _, feed := range stored {
+		current[feed.ID] = feed
+	}
+
+	seen := make(map[int64]bool)
+	for _, mFeed := range upstream {
+		seen[mFeed.ID] = true
+		id := strconv.FormatInt(mFeed.ID, 10)
+
+		old, ok := current[mFeed.ID]
+		if !ok {
+			report.Feeds.Created = append(report.Feeds.Created, id)
+		} else if feedChanged(old, mFeed.ToFeed()) {
+			report.Feeds.Updated = append(report.Feeds.Updated, id)
+		} else {
+			continue
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		// fetch again to get the icon
+		if err := lim.wait(ctx); err != nil {
+			return err
+		}
+		feed, err := h.getFeed(ctx, mFeed.ID)
+		if err != nil {
+			report.addError("get feed %v: %s", mFeed.ID, err)
+			continue
+		}
+		if err := h.store.SaveFeed(ctx, feed); err != nil {
+			report.addError("save feed %v: %s", mFeed.ID, err)
+		}
+	}
+
+	for _, feed := range stored {
+		if seen[feed.ID] {
+			continue
+		}
+		report.Feeds.Deleted = append(report.Feeds.Deleted, strconv.FormatInt(feed.ID, 10))
+		if opts.DryRun {
+			continue
+		}
+		if err := lim.wait(ctx); err != nil {
+			return err
+		}
+		if err := h.store.DeleteFeed(ctx, feed.ID); err != nil {
+			report.addError("delete feed %v: %s", feed.ID, err)
+		}
+	}
+	return nil
+}
+
+// numberValue returns numeric document value as int64
+func numberValue(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// entryChanged reports whether stored entry document differs from Miniflux entry
+func entryChanged(doc map[string]interface{}, entry *types.Entry) bool {
+	return doc["title"] != entry.Title ||
+		doc["url"] != entry.URL ||
+		doc["content"] != entry.Content ||
+		numberValue(doc["published_at"]) != entry.PublishedAt ||
+		numberValue(doc["category_id"]) != entry.CategoryID ||
+		numberValue(doc["feed_id"]) != entry.FeedID
+}
+
+func (h *Handler) reconcileEntries(ctx context.Context, lim *limiter, opts ReconcileOptions, report *Report) error {
+	expected := make(map[string]bool) // collection/id
+
+	filter := &miniflux.EntryFilter{
+		After:     opts.Since.Unix(),
+		Order:     "published_at",
+		Direction: "desc",
+		Limit:     reconcilePageSize,
+	}
+	for {
+		if err := lim.wait(ctx); err != nil {
+			return err
+		}
+		result, err := h.miniflux.Entries(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, mEntry := range result.Entries {
+			entry, err := mEntry.ToEntry()
+			if err != nil {
+				continue // entries without image are never stored
+			}
+			collection, id := h.routes.Locate(entry, mEntry.ID)
+			key := collection + "/" + id
+			expected[key] = true
+
+			if err := lim.wait(ctx); err != nil {
+				return err
+			}
+			doc, err := h.store.GetEntry(ctx, collection, id)
+			if err == store.ErrNotFound {
+				report.Entries.Created = append(report.Entries.Created, key)
+			} else if err != nil {
+				report.addError("get entry %s: %s", key, err)
+				continue
+			} else if entryChanged(doc, entry) {
+				report.Entries.Updated = append(report.Entries.Updated, key)
+			} else {
+				continue
+			}
+			if opts.DryRun {
+				continue
+			}
+
+			if err := lim.wait(ctx); err != nil {
+				return err
+			}
+			if err := h.saveEntry(ctx, mEntry.ID, collection, id, entry); err != nil {
+				report.addError("save entry %s: %s", key, err)
+			}
+		}
+
+		filter.Offset += len(result.Entries)
+		if len(result.Entries) < reconcilePageSize || filter.Offset >= result.Total {
+			break
+		}
+	}
+
+	// delete stored entries that no longer exist in Miniflux
+	since := opts.Since.Unix() * 1000 // published_at is in millisecs
+	for _, collection := range h.routes.Collections() {
+		var cursor int64
+	pages:
+		for {
+			if err := lim.wait(ctx); err != nil {
+				return err
+			}
+			docs, err := h.store.ListEntries(ctx, store.EntryQuery{Collection: collection, Cursor: cursor, Limit: reconcilePageSize})
+			if err != nil {
+				return err
+			}
+
+			for _, doc := range docs {
+				cursor = numberValue(doc["published_at"])
+				if cursor <= since {
+					break pages
+				}
+				id := strconv.FormatInt(numberValue(doc["id"]), 10)
+				key := collection + "/" + id
+				if expected[key] {
+					continue
+				}
+				report.Entries.Deleted = append(report.Entries.Deleted, key)
+				if opts.DryRun {
+					continue
+				}
+				if err := lim.wait(ctx); err != nil {
+					return err
+				}
+				// Miniflux ID is unknown here, the stale index only points to a missing document
+				if err := h.deleteEntry(ctx, 0, &store.EntryRef{Collection: collection, ID: id}); err != nil {
+					report.addError("delete entry %s: %s", key, err)
+				}
+			}
+			if len(docs) < reconcilePageSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// HandleReconcile handles reconciliation request sent through PubSub (eg. by Cloud Scheduler),
+// responds with the report.
+func (h *Handler) HandleReconcile() func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		msg, ok := c.Locals(pubsub.LocalsKey).(*pubsub.Message)
+		if !ok {
+			c.Next(fault.Permanent(errors.New("unable to retrieve PubSub message from c.Locals")))
+			return
+		}
+
+		report, err := h.reconcileMessage(context.Background(), transport.FromPush(c, msg))
+		if err != nil {
+			c.Next(err)
+			return
+		}
+		c.Status(http.StatusOK).JSON(report)
+	}
+}
+
+// ProcessReconcile runs reconciliation specified by the message
+func (h *Handler) ProcessReconcile(ctx context.Context, msg *transport.Message) error {
+	_, err := h.reconcileMessage(ctx, msg)
+	return err
+}
+
+func (h *Handler) reconcileMessage(ctx context.Context, msg *transport.Message) (*Report, error) {
+	var payload types.ReconcilePayload
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := ReconcileOptions{
+		Since:  time.Now().Add(-config.ReconcileWindow),
+		DryRun: payload.DryRun,
+		Rate:   config.ReconcileRate,
+	}
+	if payload.Since != nil {
+		opts.Since = time.Unix(*payload.Since, 0)
+	}
+	return h.Reconcile(ctx, opts)
+}
@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"server/common/miniflux"
+	"server/common/routing"
+	"server/common/store"
+	"server/common/types"
+)
+
+func newReconcileServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/categories", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 1, "title": "Badung"}, {"id": 11, "title": "Kriminal"}]`))
+	})
+	mux.HandleFunc("/v1/feeds", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 2, "title": "Feed 2", "category": {"id": 1}}]`))
+	})
+	mux.HandleFunc("/v1/feeds/2", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 2, "title": "Feed 2", "category": {"id": 1}}`))
+	})
+	mux.HandleFunc("/v1/entries", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"total": 3, "entries": [
+			{"id": 100, "feed_id": 2, "title": "New", "published_at": "2020-03-01T10:00:00Z",
+				"enclosures": [{"url": "http://image.jpg", "mime_type": "image/jpg"}], "feed": {"category": {"id": 1}}},
+			{"id": 101, "feed_id": 2, "title": "Kriminal", "published_at": "2020-03-01T09:00:00Z",
+				"enclosures": [{"url": "http://image.jpg", "mime_type": "image/jpg"}], "feed": {"category": {"id": 11}}},
+			{"id": 102, "feed_id": 2, "title": "No image", "published_at": "2020-03-01T08:00:00Z", "feed": {"category": {"id": 1}}}
+		]}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	srv := newReconcileServer()
+	defer srv.Close()
+
+	db := store.NewMemory()
+	db.SaveCategories(ctx, []types.Category{{ID: 1, Title: "Old title"}, {ID: 5, Title: "Removed"}})
+	db.SaveFeed(ctx, &types.Feed{ID: 3, Title: "Removed"})
+	removed := time.Date(2020, 3, 1, 7, 0, 0, 0, time.UTC).Unix() * 1000
+	db.SaveEntry(ctx, "entries", "99", &types.Entry{ID: 99, PublishedAt: removed})
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix() * 1000
+	db.SaveEntry(ctx, "entries", "1", &types.Entry{ID: 1, PublishedAt: old})
+
+	h := New(db, miniflux.New(miniflux.Config{BaseURL: srv.URL}), routing.Default())
+	opts := ReconcileOptions{Since: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), DryRun: true}
+
+	report, err := h.Reconcile(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Categories.Created) != 1 || len(report.Categories.Updated) != 1 || len(report.Categories.Deleted) != 1 {
+		t.Error("Wrong categories diff:", report.Categories)
+	}
+	if len(report.Feeds.Created) != 1 || len(report.Feeds.Deleted) != 1 {
+		t.Error("Wrong feeds diff:", report.Feeds)
+	}
+	kriminalID := time.Date(2020, 3, 1, 9, 0, 0, 0, time.UTC).Unix() * 1000
+	if len(report.Entries.Created) != 2 || report.Entries.Created[1] != "kriminal/"+strconv.FormatInt(kriminalID, 10) {
+		t.Error("Wrong entries created:", report.Entries.Created)
+	}
+	if len(report.Entries.Deleted) != 1 || report.Entries.Deleted[0] != "entries/99" {
+		t.Error("Wrong entries deleted:", report.Entries.Deleted)
+	}
+
+	// dry run must not write anything
+	if _, err := db.GetEntry(ctx, "entries", "100"); err != store.ErrNotFound {
+		t.Error("Dry run created an entry")
+	}
+
+	opts.DryRun = false
+	if _, err := h.Reconcile(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetEntry(ctx, "entries", "100"); err != nil {
+		t.Error("Entry not created:", err)
+	}
+	if _, err := db.GetEntry(ctx, "kriminal", strconv.FormatInt(kriminalID, 10)); err != nil {
+		t.Error("Kriminal entry not created:", err)
+	}
+	if _, err := db.GetEntry(ctx, "entries", "99"); err != store.ErrNotFound {
+		t.Error("Entry not deleted")
+	}
+	if _, err := db.GetEntry(ctx, "entries", "1"); err != nil {
+		t.Error("Entry older than since must be kept")
+	}
+	if cat, _ := db.GetCategory(ctx, "1"); cat == nil || cat.Title != "Badung" {
+		t.Error("Category not updated")
+	}
+
+	// converged, nothing to do
+	report, _ = h.Reconcile(ctx, opts)
+	if len(report.Entries.Created)+len(report.Entries.Updated)+len(report.Entries.Deleted) != 0 {
+		t.Error("Reconcile should have converged:", report.Entries)
+	}
+}
@@ -4,24 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
 
 	"github.com/fiberweb/pubsub"
 	"github.com/gofiber/fiber"
 
 	"server/common/constant"
-	"server/common/service"
+	"server/common/fault"
+	"server/common/miniflux"
+	"server/common/routing"
+	"server/common/store"
+	"server/common/transport"
 	"server/common/types"
+	"server/config"
 )
 
 // Handler represents the data syncer from Miniflux to Firestore
 type Handler struct {
-	google *service.Google
+	store    store.Store
+	miniflux *miniflux.Client
+	routes   *routing.Table
 }
 
 // New returns an instance of Handler
-func New(google *service.Google) *Handler {
-	return &Handler{google}
+func New(s store.Store, mf *miniflux.Client, routes *routing.Table) *Handler {
+	return &Handler{store: s, miniflux: mf, routes: routes}
 }
 
 // Handle handles the request
@@ -29,45 +37,47 @@ func (h *Handler) Handle() func(*fiber.Ctx) {
 	return func(c *fiber.Ctx) {
 		msg, ok := c.Locals(pubsub.LocalsKey).(*pubsub.Message)
 		if !ok {
-			c.Next(errors.New("unable to retrieve PubSub message from c.Locals"))
+			c.Next(fault.Permanent(errors.New("unable to retrieve PubSub message from c.Locals")))
 			return
 		}
-
-		var payload *types.SyncPayload
-		if err := json.Unmarshal(msg.Message.Data, &payload); err != nil {
+		if err := h.Process(context.Background(), transport.FromPush(c, msg)); err != nil {
+			// retried by PubSub unless it's permanent, eg. the entity no longer exists in Miniflux
 			c.Next(err)
 			return
 		}
-		if payload.ID == nil || payload.Type == nil || payload.Op == nil {
-			c.Next(errors.New("Invalid message payload: missing id, type or op"))
-			return
-		}
+		c.SendStatus(http.StatusOK)
+	}
+}
 
-		ctx := context.Background() // request ctx
+// Process syncs the entity specified by the message, duplicate message is ignored
+func (h *Handler) Process(ctx context.Context, msg *transport.Message) error {
+	var payload *types.SyncPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return err
+	}
+	if payload.ID == nil || payload.Type == nil || payload.Op == nil {
+		return fault.Permanent(errors.New("Invalid message payload: missing id, type or op"))
+	}
 
-		// init Firestore client
-		if err := h.google.InitFirestore(ctx); err != nil {
-			c.Next(err)
-			return
-		}
+	err := store.Once(ctx, h.store, "sync:"+msg.ID, config.DedupTTL, func() error {
+		return h.sync(ctx, payload)
+	})
+	if err == store.ErrDuplicate {
+		log.Println("[DUPLICATE] sync message", msg.ID)
+		return nil
+	}
+	return err
+}
 
-		switch *payload.Type {
-		case constant.TypeCategory:
-			if err := h.storeCategories(ctx, payload); err != nil {
-				c.Next(err)
-				return
-			}
-		case constant.TypeFeed:
-			if err := h.storeFeed(ctx, payload); err != nil {
-				c.Next(err)
-				return
-			}
-		case constant.TypeEntry:
-			if err := h.storeEntry(ctx, payload); err != nil {
-				c.Next(err)
-				return
-			}
-		}
-		c.SendStatus(http.StatusOK)
+// sync stores the entity specified by payload
+func (h *Handler) sync(ctx context.Context, payload *types.SyncPayload) error {
+	switch *payload.Type {
+	case constant.TypeCategory:
+		return h.storeCategories(ctx, payload)
+	case constant.TypeFeed:
+		return h.storeFeed(ctx, payload)
+	case constant.TypeEntry:
+		return h.storeEntry(ctx, payload)
 	}
+	return nil
 }
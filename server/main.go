@@ -3,13 +3,22 @@ package main
 import (
 	"context"
 	"log"
+	"os"
+	"time"
 
 	"github.com/fiberweb/apikey"
 	pubs "github.com/fiberweb/pubsub"
 	"github.com/gofiber/fiber"
+	_ "github.com/lib/pq"           // postgres driver
+	_ "github.com/mattn/go-sqlite3" // sqlite3 driver
 
+	"server/common/miniflux"
+	"server/common/routing"
 	"server/common/service"
+	"server/common/store"
+	"server/common/transport"
 	"server/config"
+	"server/handler/admin"
 	"server/handler/api"
 	"server/handler/events"
 	"server/handler/push"
@@ -18,17 +27,147 @@ import (
 
 var gcp *service.Google
 
-func main() {
-	ctx := context.Background()
+// sqlDrivers maps STORE config into database/sql driver name
+var sqlDrivers = map[string]string{
+	"sqlite":   "sqlite3",
+	"postgres": "postgres",
+}
 
-	// initialize Firebase app
+// loadRoutes loads entry collections routing table from file, Firestore or the built-in one
+func loadRoutes(ctx context.Context) *routing.Table {
+	var routes *routing.Table
 	var err error
-	gcp, err = service.NewGoogle(ctx, config.GCPProject)
+
+	switch {
+	case config.RoutesFile != "":
+		routes, err = routing.LoadFile(config.RoutesFile)
+	case config.RoutesDoc != "":
+		if gcp == nil {
+			gcp = initGoogle(ctx)
+		}
+		if err = gcp.InitFirestore(ctx); err == nil {
+			routes, err = routing.LoadFirestore(ctx, gcp.Firestore, config.RoutesDoc)
+		}
+	default:
+		routes = routing.Default()
+	}
+	if err != nil {
+		log.Fatalln("Unable to load routing table:", err)
+	}
+	return routes
+}
+
+// initGoogle initialize Firebase app
+func initGoogle(ctx context.Context) *service.Google {
+	g, err := service.NewGoogle(ctx, config.GCPProject)
 	if err != nil {
 		log.Fatalln("Unable to initialize Firebase app:", err)
 	}
+	return g
+}
+
+func main() {
+	ctx := context.Background()
+
+	var db store.Store
+	var messenger service.Messenger
+	var verifier service.Verifier
+
+	switch config.Store {
+	case "memory":
+		// run locally without GCP, FCM messages are only logged and any bearer token is accepted as user ID
+		local := service.NewLocal()
+		db, messenger, verifier = store.NewMemory(), local, local
+	case "firestore":
+		gcp = initGoogle(ctx)
+		db, messenger, verifier = store.NewFirestore(gcp), gcp, gcp
+	case "sqlite", "postgres":
+		// data stored in SQL database, FCM is still on Google
+		gcp = initGoogle(ctx)
+		sqlStore, err := store.NewSQL(ctx, sqlDrivers[config.Store], config.DatabaseURL)
+		if err != nil {
+			log.Fatalln("Unable to open database:", err)
+		}
+		db, messenger, verifier = sqlStore, gcp, gcp
+	default:
+		log.Fatalln("Unknown STORE:", config.Store)
+	}
 
-	app := fiber.New()
+	var publisher transport.Publisher
+	var subscriber transport.Subscriber
+	var bus *transport.Bus
+
+	switch config.Transport {
+	case "local":
+		// messages are delivered in-process to the same handlers as PubSub push endpoints
+		bus = transport.NewBus()
+		publisher, subscriber = bus, bus
+	case "pubsub":
+		if gcp == nil {
+			gcp = initGoogle(ctx)
+		}
+		google := transport.NewGoogle(gcp)
+		google.ReceiveSettings.MaxOutstandingMessages = config.WorkerConcurrency
+		google.ReceiveSettings.MaxOutstandingBytes = config.WorkerMaxOutstandingBytes
+		publisher, subscriber = google, google
+	default:
+		log.Fatalln("Unknown TRANSPORT:", config.Transport)
+	}
+
+	mf := miniflux.New(miniflux.Config{
+		BaseURL:  config.MinifluxHost,
+		Username: config.MinifluxUser,
+		Password: config.MinifluxPass,
+		Token:    config.MinifluxToken,
+
+		MaxRetries:       config.MinifluxMaxRetries,
+		RetryDelay:       config.MinifluxRetryDelay,
+		BreakerThreshold: config.MinifluxBreakerThreshold,
+		BreakerCooldown:  config.MinifluxBreakerCooldown,
+	})
+
+	routes := loadRoutes(ctx)
+	syncHandler := sync.New(db, mf, routes)
+	pushHandler := push.New(db, messenger)
+	eventsHandler := events.New(db, publisher, messenger, routes)
+	processors := map[string]transport.Handler{
+		"/pubsub/sync-data":           syncHandler.Process,
+		"/pubsub/reconcile":           syncHandler.ProcessReconcile,
+		"/pubsub/push-notification":   pushHandler.Process,
+		"/pubsub/flush-notifications": pushHandler.ProcessFlush,
+		"/pubsub/firestore-events":    eventsHandler.Process,
+	}
+
+	// run as CLI command instead of server, eg. `server reconcile -dry-run` or `server worker`
+	if len(os.Args) > 1 {
+		runCommand(ctx, os.Args[1], os.Args[2:], &commandDeps{
+			db:            db,
+			subscriber:    subscriber,
+			syncHandler:   syncHandler,
+			eventsHandler: eventsHandler,
+			routes:        routes,
+			processors:    processors,
+		})
+		return
+	}
+
+	if bus != nil {
+		go bus.Subscribe(ctx, config.SyncTopic, syncHandler.Process)
+		go bus.Subscribe(ctx, config.PushNotificationTopic, pushHandler.Process)
+		go bus.Subscribe(ctx, config.FirestoreEventsTopic, eventsHandler.Process)
+
+		// no Cloud Scheduler locally, flush pending notifications every minute
+		go func() {
+			for range time.Tick(time.Minute) {
+				if _, err := pushHandler.FlushPending(ctx); err != nil {
+					log.Println("[ERROR] flush pending notifications:", err)
+				}
+			}
+		}()
+	}
+
+	// path params are user, response and comment IDs, they must not be lowercased
+	app := fiber.New(&fiber.Settings{CaseSensitive: true})
 
 	// all /pubsub/** are to handle PubSub requests (protected by api key)
 	pubsub := app.Group("/pubsub")
@@ -43,13 +182,26 @@ func main() {
 	}))
 
 	pubsub.Use(pubs.New(pubs.Config{Debug: false})) // pubsub middleware
-	pubsub.Post("/sync-data", sync.New(gcp).Handle())
-	pubsub.Post("/push-notification", push.New(gcp).Handle())
-	pubsub.Post("/firestore-events", events.New(gcp).Handle())
-	pubsub.Use(softErrorHandler()) // always return OK response to avoid PubSub retrying
+	pubsub.Use(pubsubDeliveryAttempt(db))           // delivery attempt of handlers depending on it
+	pubsub.Post("/sync-data", syncHandler.Handle())
+	pubsub.Post("/reconcile", syncHandler.HandleReconcile())
+	pubsub.Post("/push-notification", pushHandler.Handle())
+	pubsub.Post("/flush-notifications", pushHandler.HandleFlush())
+	pubsub.Post("/firestore-events", eventsHandler.Handle())
+	pubsub.Use(pubsubErrorHandler(db)) // redeliver retryable errors, park the others in dead letters
+
+	// all /admin/** are to manage the service (protected by admin api key)
+	if config.AdminAPIKey != "" {
+		admin.New(db, processors).Routes(app, "/admin", config.AdminAPIKey)
+	}
 
 	// all /api/** are to REST apis for clients
-	api.New(gcp).Routes(app, "/api/v1")
+	apiHandler := api.New(db, routes, verifier)
+	if config.Store != "firestore" {
+		// no Firestore change events, aggregate responses written through the API
+		apiHandler.Events = publisher
+	}
+	apiHandler.Routes(app, "/api/v1")
 
 	app.Listen(config.ServicePort)
 }
@@ -1,21 +1,115 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
+	pubs "github.com/fiberweb/pubsub"
 	"github.com/gofiber/fiber"
+
+	"server/common/fault"
+	"server/common/store"
+	"server/common/transport"
+	"server/common/types"
+	"server/config"
 )
 
-// softErrorHandler is a middleware to handle error but NOT actually return error response.
-// This is to avoid PubSub retrying.
-func softErrorHandler() func(*fiber.Ctx) {
+// pubsubErrorHandler is a middleware to handle PubSub handlers error.
+// Retryable errors respond with 503 so PubSub redelivers the message,
+// after config.MaxDeliveryAttempts or on permanent error (retrying won't help)
+// the message is parked in dead letters and acked, so it can be inspected and replayed later.
+func pubsubErrorHandler(db store.Store) func(*fiber.Ctx) {
 	return func(c *fiber.Ctx) {
-		if c.Error() != nil {
-			log.Println("[ERROR]", c.Error())
+		err := c.Error()
+		if err == nil {
+			c.Next()
+			return
+		}
+
+		msg, ok := c.Locals(pubs.LocalsKey).(*pubs.Message)
+		if !ok {
+			log.Println("[ERROR]", err)
 			c.SendStatus(http.StatusOK)
 			return
 		}
+
+		ctx := context.Background()
+		attempts, aerr := deliveryAttempt(ctx, c, db, msg)
+		if aerr != nil {
+			log.Println("[ERROR] unable to count delivery attempts:", aerr)
+		}
+		dl := newDeadLetter(c.Path(), msg.Subscription, transport.FromPush(c, msg), attempts, err)
+		if redeliver(ctx, db, dl, err) {
+			c.SendStatus(http.StatusServiceUnavailable)
+			return
+		}
+		c.SendStatus(http.StatusOK)
+	}
+}
+
+// redeliver reports whether failed message should be redelivered,
+// otherwise the message is parked in dead letters.
+func redeliver(ctx context.Context, db store.Store, dl *types.DeadLetter, err error) bool {
+	retryable := fault.IsRetryable(err)
+	if retryable && dl.Attempts < config.MaxDeliveryAttempts {
+		log.Printf("[RETRY] attempt %d of message %s: %s\n", dl.Attempts, dl.ID, err)
+		return true
+	}
+
+	if derr := db.SaveDeadLetter(ctx, dl); derr != nil {
+		log.Println("[ERROR] unable to save dead letter:", derr)
+		if retryable {
+			// can't park it, let PubSub keep it
+			return true
+		}
+	}
+	log.Printf("[DEAD-LETTER] message %s after %d attempts: %s\n", dl.ID, dl.Attempts, err)
+	return false
+}
+
+// deliveryAttempt returns the delivery attempt of current message.
+// PubSub only sends deliveryAttempt when the subscription has dead letter policy,
+// otherwise the attempts are counted in the store.
+func deliveryAttempt(ctx context.Context, c *fiber.Ctx, db store.Store, msg *pubs.Message) (int, error) {
+	if attempt := transport.PushAttempt(c); attempt > 0 {
+		return attempt, nil
+	}
+	return db.IncrementAttempts(ctx, msg.Message.ID, config.DedupTTL)
+}
+
+// attemptPaths are the handlers depending on the delivery attempt, whose attempts are counted before handling
+// (push notification only records delivery stats of the last attempt). Others only count failed attempts.
+var attemptPaths = map[string]bool{"/pubsub/push-notification": true}
+
+// pubsubDeliveryAttempt is a middleware counting delivery attempt of PubSub message to attemptPaths
+// before it's handled, the failed attempt isn't counted again by pubsubErrorHandler.
+func pubsubDeliveryAttempt(db store.Store) func(*fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		msg, ok := c.Locals(pubs.LocalsKey).(*pubs.Message)
+		if ok && attemptPaths[c.Path()] {
+			attempt, err := deliveryAttempt(context.Background(), c, db, msg)
+			if err != nil {
+				log.Println("[ERROR] unable to count delivery attempts:", err)
+			} else {
+				c.Locals(transport.AttemptKey, attempt)
+			}
+		}
 		c.Next()
 	}
 }
+
+// newDeadLetter creates dead letter from failed message
+func newDeadLetter(path, subscription string, msg *transport.Message, attempts int, err error) *types.DeadLetter {
+	return &types.DeadLetter{
+		ID:           msg.ID,
+		Path:         path,
+		Subscription: subscription,
+		Data:         msg.Data,
+		Attributes:   msg.Attributes,
+		Attempts:     attempts,
+		Error:        err.Error(),
+		CreatedAt:    time.Now(),
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"server/common/fault"
+	"server/common/store"
+	"server/common/transport"
+	"server/config"
+)
+
+// workerCommand pulls messages of config.WorkerSubscriptions until SIGINT or SIGTERM,
+// an alternative to PubSub push endpoints for long running processes.
+func workerCommand(ctx context.Context, args []string, deps *commandDeps) {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	pairs := fs.String("subscriptions", config.WorkerSubscriptions, "comma separated handler=subscription, eg. push-notification=PushNotificationWorker")
+	fs.Parse(args)
+
+	subscriptions, err := parseSubscriptions(strings.Split(*pairs, ","), deps.processors)
+	if err != nil {
+		log.Fatalln("Invalid worker subscriptions:", err)
+	}
+
+	ctx, cancel := withSignals(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for path, subscription := range subscriptions {
+		h := workerHandler(deps.db, path, subscription, deps.processors[path])
+		wg.Add(1)
+		go func(subscription string) {
+			defer wg.Done()
+			log.Println("Worker pulling", subscription)
+			if err := deps.subscriber.Subscribe(ctx, subscription, h); err != nil {
+				log.Printf("[ERROR] subscription %s stopped: %s\n", subscription, err)
+				cancel()
+			}
+		}(subscription)
+	}
+	wg.Wait()
+}
+
+// withSignals returns ctx canceled on SIGINT or SIGTERM
+func withSignals(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			log.Println("Stopping, waiting for outstanding messages...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// parseSubscriptions parses handler=subscription pairs into processor path => subscription
+func parseSubscriptions(pairs []string, processors map[string]transport.Handler) (map[string]string, error) {
+	subscriptions := make(map[string]string)
+	for _, pair := range pairs {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("%q is not handler=subscription", pair)
+		}
+		path := "/pubsub/" + kv[0]
+		if _, ok := processors[path]; !ok {
+			return nil, fmt.Errorf("unknown handler %q", kv[0])
+		}
+		subscriptions[path] = kv[1]
+	}
+	if len(subscriptions) == 0 {
+		return nil, fmt.Errorf("no subscriptions configured")
+	}
+	return subscriptions, nil
+}
+
+// workerHandler wraps processor with the same retry and dead letter policy as push endpoints,
+// dead letters keep the push endpoint path so they can be replayed by admin APIs.
+func workerHandler(db store.Store, path, subscription string, process transport.Handler) transport.Handler {
+	return func(ctx context.Context, msg *transport.Message) error {
+		if msg.DeliveryAttempt == 0 && attemptPaths[path] {
+			attempt, aerr := db.IncrementAttempts(ctx, msg.ID, config.DedupTTL)
+			if aerr != nil {
+				log.Println("[ERROR] unable to count delivery attempts:", aerr)
+			}
+			msg.DeliveryAttempt = attempt
+		}
+		err := process(ctx, msg)
+		if err == nil {
+			return nil
+		}
+
+		attempts := msg.DeliveryAttempt
+		if attempts == 0 {
+			var aerr error
+			if attempts, aerr = db.IncrementAttempts(ctx, msg.ID, config.DedupTTL); aerr != nil {
+				log.Println("[ERROR] unable to count delivery attempts:", aerr)
+			}
+		}
+		if redeliver(ctx, db, newDeadLetter(path, subscription, msg, attempts, err), err) {
+			return fault.Retryable(err) // nack
+		}
+		return nil // parked, ack
+	}
+}